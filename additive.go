@@ -0,0 +1,144 @@
+//-----------------------------------------------------------------------------
+/*
+
+Additive Synthesis Oscillator
+
+Sum a list of sine partials, each with a frequency ratio, amplitude, initial
+phase and an optional ADSR envelope. Partials above the Nyquist frequency
+are dropped.
+
+*/
+//-----------------------------------------------------------------------------
+
+package main
+
+import (
+	"errors"
+	"math"
+)
+
+//-----------------------------------------------------------------------------
+
+type Partial struct {
+	ratio float32 // frequency ratio to the fundamental
+	amp   float32 // amplitude
+	phase float32 // initial phase (0..1)
+	env   *ADSR   // optional envelope
+}
+
+type Additive struct {
+	partials []Partial
+	oscs     []*LUT  // per partial sine oscillator
+	audible  []bool  // is the partial below nyquist?
+	gain     float32 // output normalisation
+}
+
+// Return an additive oscillator for a list of partials.
+func NewAdditive(partials []Partial, f float32, rate int) (*Additive, error) {
+	if len(partials) == 0 {
+		return nil, errors.New("no partials")
+	}
+	a := &Additive{
+		partials: partials,
+		oscs:     make([]*LUT, len(partials)),
+		audible:  make([]bool, len(partials)),
+	}
+	var sum float32
+	for i, p := range partials {
+		if p.ratio <= 0 {
+			return nil, errors.New("bad partial frequency ratio")
+		}
+		a.oscs[i] = NewLUT_Sine(f*p.ratio, rate)
+		a.oscs[i].SetFixed(true)
+		// the cosine table starts at phase 0.75 for a sine
+		a.oscs[i].SetPhase(p.phase + 0.75)
+		sum += float32(math.Abs(float64(p.amp)))
+	}
+	if sum != 0 {
+		a.gain = 1.0 / sum
+	}
+	a.SetStep(f, rate)
+	return a, nil
+}
+
+//-----------------------------------------------------------------------------
+
+func (a *Additive) SetStep(f float32, rate int) {
+	nyquist := float32(rate) / 2.0
+	for i, p := range a.partials {
+		fp := f * p.ratio
+		a.audible[i] = fp < nyquist
+		a.oscs[i].SetStep(fp, rate)
+	}
+}
+
+// Start the partial envelopes.
+func (a *Additive) Attack() {
+	for _, p := range a.partials {
+		if p.env != nil {
+			p.env.Attack()
+		}
+	}
+}
+
+// Release the partial envelopes.
+func (a *Additive) Release() {
+	for _, p := range a.partials {
+		if p.env != nil {
+			p.env.Release()
+		}
+	}
+}
+
+func (a *Additive) Sample() float32 {
+	var y float32
+	for i, p := range a.partials {
+		amp := p.amp
+		if p.env != nil {
+			amp *= p.env.Sample()
+		}
+		if a.audible[i] {
+			y += amp * a.oscs[i].Sample()
+		}
+	}
+	return y * a.gain
+}
+
+//-----------------------------------------------------------------------------
+
+// Return the partials for a set of 9 organ drawbar settings (0..8).
+func drawbar_partials(drawbars [9]int) []Partial {
+	// 16', 5 1/3', 8', 4', 2 2/3', 2', 1 3/5', 1 1/3', 1'
+	ratios := [9]float32{0.5, 1.5, 1, 2, 3, 4, 5, 6, 8}
+	var partials []Partial
+	for i, d := range drawbars {
+		if d <= 0 {
+			continue
+		}
+		if d > 8 {
+			d = 8
+		}
+		// each drawbar step is 3 dB
+		amp := float32(math.Pow(10.0, -3.0*float64(8-d)/20.0))
+		partials = append(partials, Partial{ratio: ratios[i], amp: amp})
+	}
+	return partials
+}
+
+// Return the partials for a Risset style bell with a decay time of t seconds.
+func bell_partials(t float32, rate int) ([]Partial, error) {
+	ratios := []float32{0.56, 0.563, 0.92, 0.923, 1.19, 1.7, 2.0, 2.74, 3.0, 3.76, 4.07}
+	amps := []float32{1.0, 0.67, 1.0, 1.8, 2.67, 1.67, 1.46, 1.33, 1.33, 1.0, 1.33}
+	decays := []float32{1.0, 0.9, 0.65, 0.55, 0.325, 0.35, 0.25, 0.2, 0.15, 0.1, 0.075}
+	partials := make([]Partial, len(ratios))
+	for i := range partials {
+		env, err := NewAD_Envelope(0.002, decays[i]*t, rate)
+		if err != nil {
+			return nil, err
+		}
+		partials[i] = Partial{ratio: ratios[i], amp: amps[i], env: env}
+	}
+	return partials, nil
+}
+
+//-----------------------------------------------------------------------------
@@ -0,0 +1,152 @@
+//-----------------------------------------------------------------------------
+/*
+
+Band Limited Oscillators
+
+The naive sawtooth/square lookup tables have discontinuities that alias
+badly at high frequencies. These oscillators generate the naive waveform
+from a phase accumulator and then smooth out each discontinuity with a
+polynomial band limited step (PolyBLEP) correction.
+
+The variable width pulse is the difference of two phase offset sawtooths,
+so the width can be modulated at audio rate.
+
+*/
+//-----------------------------------------------------------------------------
+
+package main
+
+import "math"
+
+//-----------------------------------------------------------------------------
+
+// Return the PolyBLEP correction for a phase t (0..1) and a phase step dt.
+// This is non-zero only within one sample of the discontinuity at t = 0.
+func poly_blep(t, dt float32) float32 {
+	if t < dt {
+		// just after the discontinuity
+		t /= dt
+		return t + t - t*t - 1.0
+	}
+	if t > 1.0-dt {
+		// just before the discontinuity
+		t = (t - 1.0) / dt
+		return t*t + t + t + 1.0
+	}
+	return 0
+}
+
+//-----------------------------------------------------------------------------
+
+type BLEPShape int
+
+const (
+	blep_sawtooth BLEPShape = iota
+	blep_square
+	blep_pulse
+)
+
+type BLEP struct {
+	shape BLEPShape // waveform shape
+	x     float32   // phase (0..1)
+	step  float32   // phase step per sample
+	width float32   // pulse width (0..1)
+	k     float32   // pulse level scale
+	wrap  bool      // the phase wrapped on the last sample
+}
+
+func (t *BLEP) SetStep(f float32, rate int) {
+	t.step = f / float32(rate)
+}
+
+// Set the phase (0..1 is one cycle).
+func (t *BLEP) SetPhase(p float32) {
+	t.x = p - float32(math.Floor(float64(p)))
+	if t.x >= 1.0 {
+		// float32 rounding
+		t.x = 0
+	}
+}
+
+// Return true if the phase wrapped around on the last sample.
+func (t *BLEP) Wrapped() bool {
+	return t.wrap
+}
+
+// Set the pulse width (0..1). This may be modulated per sample.
+func (t *BLEP) SetWidth(w float32) {
+	if w < 0.01 {
+		w = 0.01
+	} else if w > 0.99 {
+		w = 0.99
+	}
+	t.width = w
+	// scale the larger of the two levels to 1
+	t.k = 0.5 / float32(math.Max(float64(w), float64(1.0-w)))
+}
+
+func (t *BLEP) Sample() float32 {
+	var y float32
+	switch t.shape {
+	case blep_sawtooth:
+		y = (2.0 * t.x) - 1.0
+		y -= poly_blep(t.x, t.step)
+	case blep_square:
+		if t.x < 0.5 {
+			y = -1.0
+		} else {
+			y = 1.0
+		}
+		// rising edge at 0.5, falling edge at 0
+		x := t.x + 0.5
+		if x >= 1.0 {
+			x -= 1.0
+		}
+		y += poly_blep(x, t.step)
+		y -= poly_blep(t.x, t.step)
+	case blep_pulse:
+		// the difference of two sawtooths offset by the pulse width
+		x := t.x + t.width
+		if x >= 1.0 {
+			x -= 1.0
+		}
+		// this has zero mean for any width, so it can be modulated without a dc shift.
+		// the levels are -2w and 2(1-w), scaled so the waveform stays within -1..1.
+		y = 2.0 * (t.x - x)
+		y -= poly_blep(t.x, t.step)
+		y += poly_blep(x, t.step)
+		y *= t.k
+	default:
+		panic("bad blep shape")
+	}
+	// step the x position
+	t.x += t.step
+	t.wrap = t.x >= 1.0
+	if t.wrap {
+		t.x -= 1.0
+	}
+	return y
+}
+
+//-----------------------------------------------------------------------------
+
+func NewBLEP_Sawtooth(f float32, rate int) *BLEP {
+	t := &BLEP{shape: blep_sawtooth}
+	t.SetStep(f, rate)
+	return t
+}
+
+func NewBLEP_Square(f float32, rate int) *BLEP {
+	t := &BLEP{shape: blep_square}
+	t.SetStep(f, rate)
+	return t
+}
+
+func NewBLEP_Pulse(f, w float32, rate int) *BLEP {
+	t := &BLEP{shape: blep_pulse}
+	t.SetStep(f, rate)
+	t.SetWidth(w)
+	return t
+}
+
+//-----------------------------------------------------------------------------
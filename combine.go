@@ -0,0 +1,101 @@
+//-----------------------------------------------------------------------------
+/*
+
+Oscillator Combinations
+
+Hard sync: a master oscillator resets the phase of a slave oscillator
+each time the master completes a cycle.
+
+Ring and amplitude modulation: multiply the outputs of two generators.
+
+*/
+//-----------------------------------------------------------------------------
+
+package main
+
+//-----------------------------------------------------------------------------
+
+// A Generator returns a sample value each time it is called.
+type Generator interface {
+	Sample() float32
+}
+
+// An Oscillator is a periodic generator with an observable phase.
+type Oscillator interface {
+	Generator
+	SetStep(f float32, rate int)
+	SetPhase(p float32)
+	Wrapped() bool
+}
+
+//-----------------------------------------------------------------------------
+
+type HardSync struct {
+	master Oscillator
+	slave  Oscillator
+}
+
+// Return a hard sync combination. The output is the slave oscillator.
+func NewHardSync(master, slave Oscillator) *HardSync {
+	return &HardSync{
+		master: master,
+		slave:  slave,
+	}
+}
+
+func (s *HardSync) Sample() float32 {
+	s.master.Sample()
+	if s.master.Wrapped() {
+		// reset the slave at the start of each master cycle
+		s.slave.SetPhase(0)
+	}
+	return s.slave.Sample()
+}
+
+//-----------------------------------------------------------------------------
+
+type RingMod struct {
+	a, b Generator
+}
+
+// Return a ring modulator: the product of two generators.
+func NewRingMod(a, b Generator) *RingMod {
+	return &RingMod{
+		a: a,
+		b: b,
+	}
+}
+
+func (r *RingMod) Sample() float32 {
+	return r.a.Sample() * r.b.Sample()
+}
+
+//-----------------------------------------------------------------------------
+
+type AM struct {
+	carrier   Generator
+	modulator Generator
+	depth     float32 // modulation depth (0..1)
+}
+
+// Return an amplitude modulator.
+// With depth 1 the carrier level goes from 0 to 1 as the modulator goes from -1 to 1.
+func NewAM(carrier, modulator Generator, depth float32) *AM {
+	return &AM{
+		carrier:   carrier,
+		modulator: modulator,
+		depth:     depth,
+	}
+}
+
+// Set the modulation depth (0..1).
+func (a *AM) SetDepth(depth float32) {
+	a.depth = depth
+}
+
+func (a *AM) Sample() float32 {
+	m := 0.5 * (1.0 - a.modulator.Sample())
+	return a.carrier.Sample() * (1.0 - (a.depth * m))
+}
+
+//-----------------------------------------------------------------------------
@@ -0,0 +1,88 @@
+//-----------------------------------------------------------------------------
+/*
+
+Delay Lines
+
+A circular buffer delay line with fractional (linearly interpolated) delay
+lengths. Used by the physical models.
+
+*/
+//-----------------------------------------------------------------------------
+
+package main
+
+//-----------------------------------------------------------------------------
+
+type Delay struct {
+	buf   []float32 // circular buffer
+	wr    int       // write index
+	delay float32   // delay length in samples
+	d0    int       // integer part of the delay
+	k     float32   // fractional part of the delay
+	out   float32   // last output
+}
+
+// Return a delay line with a maximum delay of n samples.
+func NewDelay(n int) *Delay {
+	return &Delay{
+		buf: make([]float32, n+2),
+	}
+}
+
+// Set the delay length in samples.
+func (d *Delay) SetDelay(delay float32) {
+	max := float32(len(d.buf) - 2)
+	if delay < 0 {
+		delay = 0
+	} else if delay > max {
+		delay = max
+	}
+	d.delay = delay
+	d.d0 = int(delay)
+	d.k = delay - float32(d.d0)
+}
+
+// Return the delay length in samples.
+func (d *Delay) Length() float32 {
+	return d.delay
+}
+
+// Clear the delay line.
+func (d *Delay) Clear() {
+	for i := range d.buf {
+		d.buf[i] = 0
+	}
+	d.out = 0
+}
+
+// Return the last output of the delay line.
+func (d *Delay) LastOut() float32 {
+	return d.out
+}
+
+// Write an input sample and return the delayed output.
+func (d *Delay) Tick(x float32) float32 {
+	d.buf[d.wr] = x
+	d.out = d.tap()
+	d.wr++
+	if d.wr == len(d.buf) {
+		d.wr = 0
+	}
+	return d.out
+}
+
+// Return the delayed sample relative to the current write index.
+func (d *Delay) tap() float32 {
+	n := len(d.buf)
+	i0 := d.wr - d.d0
+	if i0 < 0 {
+		i0 += n
+	}
+	i1 := i0 - 1
+	if i1 < 0 {
+		i1 += n
+	}
+	return linear(d.buf[i0], d.buf[i1], d.k)
+}
+
+//-----------------------------------------------------------------------------
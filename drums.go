@@ -0,0 +1,431 @@
+//-----------------------------------------------------------------------------
+/*
+
+Drum Synthesis
+
+Analog (808/909) style drum voices:
+
+kick: a sine with a pitch envelope sweep and a noise click
+snare: two damped sines for the body plus filtered noise for the snares
+hats: a cluster of six detuned square waves through bandpass and highpass filters
+clap: bandpassed noise with a multi-burst envelope and a decaying tail
+
+Each voice has tune (semitones), decay (seconds) and tone (0..1) controls.
+A drum kit maps the voices onto the General MIDI percussion notes.
+
+*/
+//-----------------------------------------------------------------------------
+
+package main
+
+import "math"
+
+//-----------------------------------------------------------------------------
+
+type Drum interface {
+	Generator
+	Trigger(velocity uint)
+	SetTune(semitones float32)
+	SetDecay(t float32)
+	SetTone(tone float32)
+	Active() bool
+}
+
+// Return an envelope with an instant attack and a decay time of t seconds.
+func drum_env(t float32, rate int) ADSR {
+	if t < 0 {
+		t = 0
+	}
+	e, err := NewAD_Envelope(0, t, rate)
+	if err != nil {
+		panic(err)
+	}
+	return *e
+}
+
+// Return the frequency ratio for a tuning in semitones.
+func drum_tune(semitones float32) float32 {
+	return float32(math.Pow(2.0, float64(semitones)/NOTES_IN_OCTAVE))
+}
+
+//-----------------------------------------------------------------------------
+// Kick
+
+const kick_frequency = 50.0    // base frequency
+const kick_sweep_decay = 0.04  // pitch sweep decay time
+const kick_click_decay = 0.003 // click decay time
+
+type Kick struct {
+	osc   *LUT    // sine oscillator
+	noise *Noise  // click source
+	aenv  ADSR    // amplitude envelope
+	penv  ADSR    // pitch envelope
+	cenv  ADSR    // click envelope
+	f     float32 // frequency
+	tone  float32 // sweep depth and click level
+	gain  float32 // velocity gain
+	rate  int     // sample rate
+}
+
+// Return a kick drum voice.
+func NewKick(rate int) *Kick {
+	k := &Kick{
+		osc:   NewLUT_Sine(kick_frequency, rate),
+		noise: NewNoise_White(1),
+		aenv:  drum_env(0.5, rate),
+		penv:  drum_env(kick_sweep_decay, rate),
+		cenv:  drum_env(kick_click_decay, rate),
+		rate:  rate,
+	}
+	k.SetTune(0)
+	k.SetTone(0.5)
+	return k
+}
+
+func (k *Kick) SetTune(semitones float32) {
+	k.f = kick_frequency * drum_tune(semitones)
+	k.osc.SetStep(k.f, k.rate)
+}
+
+func (k *Kick) SetDecay(t float32) {
+	k.aenv.kd = get_k(t, k.rate)
+}
+
+func (k *Kick) SetTone(tone float32) {
+	k.tone = tone
+}
+
+func (k *Kick) Trigger(velocity uint) {
+	k.gain = cc_to_float(velocity)
+	// start on a zero crossing of the sine
+	k.osc.SetPhase(0.75)
+	k.aenv.Attack()
+	k.penv.Attack()
+	k.cenv.Attack()
+}
+
+func (k *Kick) Active() bool {
+	return k.aenv.state != idle
+}
+
+func (k *Kick) Sample() float32 {
+	if !k.Active() {
+		return 0
+	}
+	// sweep down from up to 8x the base frequency
+	fm := k.f * 7.0 * k.tone * k.penv.Sample()
+	y := k.osc.SampleMod(fm, 0) * k.aenv.Sample()
+	y += 0.3 * k.tone * k.noise.Sample() * k.cenv.Sample()
+	return k.gain * y
+}
+
+//-----------------------------------------------------------------------------
+// Snare
+
+const snare_frequency = 180.0 // body frequency
+const snare_ratio = 1.83      // second body mode ratio
+
+type Snare struct {
+	body0, body1 *LUT   // body oscillators
+	noise        *Noise // snare source
+	hpf          Biquad // snare highpass
+	benv         ADSR   // body envelope
+	nenv         ADSR   // snare envelope
+	tone         float32
+	gain         float32
+	rate         int
+}
+
+// Return a snare drum voice.
+func NewSnare(rate int) *Snare {
+	s := &Snare{
+		body0: NewLUT_Sine(snare_frequency, rate),
+		body1: NewLUT_Sine(snare_frequency*snare_ratio, rate),
+		noise: NewNoise_White(2),
+		benv:  drum_env(0.1, rate),
+		nenv:  drum_env(0.2, rate),
+		rate:  rate,
+	}
+	s.SetTune(0)
+	s.SetTone(0.5)
+	return s
+}
+
+func (s *Snare) SetTune(semitones float32) {
+	f := snare_frequency * drum_tune(semitones)
+	s.body0.SetStep(f, s.rate)
+	s.body1.SetStep(f*snare_ratio, s.rate)
+	s.hpf.SetHighPass(8.0*f, 0.707, s.rate)
+}
+
+// Set the snare decay time. The body decays in half the time.
+func (s *Snare) SetDecay(t float32) {
+	s.benv.kd = get_k(0.5*t, s.rate)
+	s.nenv.kd = get_k(t, s.rate)
+}
+
+// Set the balance of body (0) and snares (1).
+func (s *Snare) SetTone(tone float32) {
+	s.tone = tone
+}
+
+func (s *Snare) Trigger(velocity uint) {
+	s.gain = cc_to_float(velocity)
+	s.body0.SetPhase(0.75)
+	s.body1.SetPhase(0.75)
+	s.benv.Attack()
+	s.nenv.Attack()
+}
+
+func (s *Snare) Active() bool {
+	return s.benv.state != idle || s.nenv.state != idle
+}
+
+func (s *Snare) Sample() float32 {
+	if !s.Active() {
+		return 0
+	}
+	body := (0.6*s.body0.Sample() + 0.4*s.body1.Sample()) * s.benv.Sample()
+	snare := s.hpf.Process(s.noise.Sample()) * s.nenv.Sample()
+	return s.gain * (((1.0 - s.tone) * body) + (s.tone * snare))
+}
+
+//-----------------------------------------------------------------------------
+// Hi-Hats
+
+// 808 hi-hat oscillator frequencies
+var hat_frequencies = [6]float32{205.3, 304.4, 369.6, 522.7, 540.0, 800.0}
+
+const hat_bandpass = 10000.0  // bandpass center frequency
+const hat_choke_decay = 0.005 // decay time when choked
+
+type Hat struct {
+	osc   [6]*BLEP // square wave cluster
+	bpf   Biquad   // bandpass filter
+	hpf   Biquad   // highpass filter
+	env   ADSR     // amplitude envelope
+	decay float32  // decay time
+	gain  float32  // velocity gain
+	rate  int      // sample rate
+}
+
+// Return a hi-hat voice with a decay time of t seconds.
+func NewHat(t float32, rate int) *Hat {
+	h := &Hat{
+		env:   drum_env(t, rate),
+		decay: t,
+		rate:  rate,
+	}
+	for i := range h.osc {
+		h.osc[i] = NewBLEP_Square(hat_frequencies[i], rate)
+	}
+	h.SetTune(0)
+	h.SetTone(0.5)
+	return h
+}
+
+// Return a closed hi-hat voice.
+func NewHat_Closed(rate int) *Hat {
+	return NewHat(0.05, rate)
+}
+
+// Return an open hi-hat voice.
+func NewHat_Open(rate int) *Hat {
+	return NewHat(0.4, rate)
+}
+
+func (h *Hat) SetTune(semitones float32) {
+	k := drum_tune(semitones)
+	for i := range h.osc {
+		h.osc[i].SetStep(hat_frequencies[i]*k, h.rate)
+	}
+	h.bpf.SetBandPass(hat_bandpass*k, 1.0, h.rate)
+}
+
+func (h *Hat) SetDecay(t float32) {
+	h.decay = t
+	h.env.kd = get_k(t, h.rate)
+}
+
+// Set the highpass cutoff from 4 kHz (0) to 12 kHz (1).
+func (h *Hat) SetTone(tone float32) {
+	h.hpf.SetHighPass(4000.0+(8000.0*tone), 0.707, h.rate)
+}
+
+func (h *Hat) Trigger(velocity uint) {
+	h.gain = cc_to_float(velocity)
+	h.env.kd = get_k(h.decay, h.rate)
+	h.env.Attack()
+}
+
+// Cut off a sounding hat, e.g. an open hat by a closed hat.
+func (h *Hat) Choke() {
+	// a fast decay rather than a click
+	h.env.kd = get_k(hat_choke_decay, h.rate)
+}
+
+func (h *Hat) Active() bool {
+	return h.env.state != idle
+}
+
+func (h *Hat) Sample() float32 {
+	var x float32
+	for _, o := range h.osc {
+		x += o.Sample()
+	}
+	// keep the filters running so a retrigger doesn't click
+	y := h.hpf.Process(h.bpf.Process(x / 6.0))
+	if !h.Active() {
+		return 0
+	}
+	return 2.0 * h.gain * y * h.env.Sample()
+}
+
+//-----------------------------------------------------------------------------
+// Clap
+
+const clap_frequency = 1200.0 // bandpass center frequency
+const clap_bursts = 3         // number of bursts before the tail
+const clap_spacing = 0.011    // time between bursts
+const clap_burst_decay = 0.01 // burst decay time
+
+type Clap struct {
+	noise *Noise  // noise source
+	bpf   Biquad  // bandpass filter
+	benv  ADSR    // burst envelope
+	tenv  ADSR    // tail envelope
+	n     int     // samples since the trigger
+	dn    int     // samples between bursts
+	f     float32 // bandpass center frequency
+	tone  float32 // bandpass resonance
+	gain  float32
+	rate  int
+}
+
+// Return a hand clap voice.
+func NewClap(rate int) *Clap {
+	c := &Clap{
+		noise: NewNoise_White(3),
+		benv:  drum_env(clap_burst_decay, rate),
+		tenv:  drum_env(0.2, rate),
+		dn:    int(clap_spacing * float32(rate)),
+		rate:  rate,
+	}
+	// idle until triggered
+	c.n = clap_bursts * c.dn
+	c.SetTune(0)
+	c.SetTone(0.5)
+	return c
+}
+
+func (c *Clap) SetTune(semitones float32) {
+	c.f = clap_frequency * drum_tune(semitones)
+	c.bpf.SetBandPass(c.f, 0.5+(3.5*c.tone), c.rate)
+}
+
+// Set the tail decay time.
+func (c *Clap) SetDecay(t float32) {
+	c.tenv.kd = get_k(t, c.rate)
+}
+
+// Set the bandpass resonance from wide (0) to narrow (1).
+func (c *Clap) SetTone(tone float32) {
+	c.tone = tone
+	c.bpf.SetBandPass(c.f, 0.5+(3.5*tone), c.rate)
+}
+
+func (c *Clap) Trigger(velocity uint) {
+	c.gain = cc_to_float(velocity)
+	c.n = 0
+	c.tenv.Idle()
+}
+
+func (c *Clap) Active() bool {
+	return c.n < clap_bursts*c.dn || c.tenv.state != idle
+}
+
+func (c *Clap) Sample() float32 {
+	if !c.Active() {
+		return 0
+	}
+	if c.n%c.dn == 0 && c.n < clap_bursts*c.dn {
+		// retrigger the burst, the last burst starts the tail
+		c.benv.Attack()
+		if c.n == (clap_bursts-1)*c.dn {
+			c.tenv.Attack()
+		}
+	}
+	c.n++
+	env := c.benv.Sample() + (0.5 * c.tenv.Sample())
+	return 2.0 * c.gain * c.bpf.Process(c.noise.Sample()) * env
+}
+
+//-----------------------------------------------------------------------------
+// Drum Kit
+
+// General MIDI percussion notes
+const (
+	gm_kick       = 36
+	gm_snare      = 38
+	gm_clap       = 39
+	gm_closed_hat = 42
+	gm_open_hat   = 46
+)
+
+type DrumKit struct {
+	kick       *Kick
+	snare      *Snare
+	clap       *Clap
+	closed_hat *Hat
+	open_hat   *Hat
+	all        []Drum        // all voices
+	voices     map[uint]Drum // midi note to voice
+}
+
+// Return a drum kit.
+func NewDrumKit(rate int) *DrumKit {
+	k := &DrumKit{
+		kick:       NewKick(rate),
+		snare:      NewSnare(rate),
+		clap:       NewClap(rate),
+		closed_hat: NewHat_Closed(rate),
+		open_hat:   NewHat_Open(rate),
+	}
+	k.all = []Drum{k.kick, k.snare, k.clap, k.closed_hat, k.open_hat}
+	k.voices = map[uint]Drum{
+		gm_kick:       k.kick,
+		gm_snare:      k.snare,
+		gm_clap:       k.clap,
+		gm_closed_hat: k.closed_hat,
+		gm_open_hat:   k.open_hat,
+	}
+	return k
+}
+
+// Return the drum voice for a midi note (nil if there is none).
+func (k *DrumKit) Voice(note uint) Drum {
+	return k.voices[note]
+}
+
+// Trigger the drum voice for a midi note.
+func (k *DrumKit) NoteOn(note uint, velocity uint) {
+	d := k.voices[note]
+	if d == nil || velocity == 0 {
+		return
+	}
+	if note == gm_closed_hat {
+		// the closed hat chokes the open hat
+		k.open_hat.Choke()
+	}
+	d.Trigger(velocity)
+}
+
+func (k *DrumKit) Sample() float32 {
+	var y float32
+	for _, d := range k.all {
+		y += d.Sample()
+	}
+	return y
+}
+
+//-----------------------------------------------------------------------------
@@ -0,0 +1,179 @@
+//-----------------------------------------------------------------------------
+/*
+
+DX7 Patches
+
+Decode Yamaha DX7 voice data from 32 voice bulk dump sysex banks.
+
+*/
+//-----------------------------------------------------------------------------
+
+package main
+
+import (
+	"errors"
+	"fmt"
+	"io/ioutil"
+	"strings"
+)
+
+//-----------------------------------------------------------------------------
+
+const dx7_voices = 32           // voices in a bank
+const dx7_voice_size = 128      // bytes in a packed voice
+const dx7_op_size = 17          // bytes in a packed operator
+const dx7_bank_header_size = 6  // sysex header bytes
+const dx7_bank_data_size = 4096 // sysex data bytes
+
+type DX7_Operator struct {
+	rates  [4]uint8 // envelope rates (0..99)
+	levels [4]uint8 // envelope levels (0..99)
+	bp     uint8    // keyboard level scaling break point
+	ld     uint8    // keyboard level scaling left depth
+	rd     uint8    // keyboard level scaling right depth
+	lc     uint8    // keyboard level scaling left curve
+	rc     uint8    // keyboard level scaling right curve
+	rs     uint8    // keyboard rate scaling (0..7)
+	ams    uint8    // amplitude modulation sensitivity
+	kvs    uint8    // key velocity sensitivity (0..7)
+	ol     uint8    // output level (0..99)
+	fixed  bool     // fixed frequency mode, else ratio mode
+	fc     uint8    // frequency coarse (0..31)
+	ff     uint8    // frequency fine (0..99)
+	detune uint8    // detune (0..14, 7 is centered)
+}
+
+type DX7_Patch struct {
+	name      string
+	ops       [6]DX7_Operator // operators 1..6
+	pr        [4]uint8        // pitch envelope rates
+	pl        [4]uint8        // pitch envelope levels
+	alg       uint8           // algorithm (0..31)
+	fb        uint8           // feedback (0..7)
+	oks       bool            // oscillator key sync
+	lfs       uint8           // lfo speed
+	lfd       uint8           // lfo delay
+	lpmd      uint8           // lfo pitch modulation depth
+	lamd      uint8           // lfo amplitude modulation depth
+	lks       bool            // lfo key sync
+	lfw       uint8           // lfo waveform
+	lpms      uint8           // lfo pitch modulation sensitivity
+	transpose uint8           // transpose (0..48, 24 is C3)
+}
+
+func (p *DX7_Patch) String() string {
+	return p.name
+}
+
+//-----------------------------------------------------------------------------
+
+// Decode a packed 128 byte voice.
+func decode_dx7_voice(b []byte) *DX7_Patch {
+	p := &DX7_Patch{}
+	// operator 6 is first
+	for i := 0; i < 6; i++ {
+		x := b[i*dx7_op_size : (i+1)*dx7_op_size]
+		op := &p.ops[5-i]
+		for j := 0; j < 4; j++ {
+			op.rates[j] = x[j] & 0x7f
+			op.levels[j] = x[4+j] & 0x7f
+		}
+		op.bp = x[8] & 0x7f
+		op.ld = x[9] & 0x7f
+		op.rd = x[10] & 0x7f
+		op.lc = x[11] & 3
+		op.rc = (x[11] >> 2) & 3
+		op.rs = x[12] & 7
+		op.detune = (x[12] >> 3) & 15
+		op.ams = x[13] & 3
+		op.kvs = (x[13] >> 2) & 7
+		op.ol = x[14] & 0x7f
+		op.fixed = x[15]&1 != 0
+		op.fc = (x[15] >> 1) & 31
+		op.ff = x[16] & 0x7f
+	}
+	x := b[6*dx7_op_size:]
+	for j := 0; j < 4; j++ {
+		p.pr[j] = x[j] & 0x7f
+		p.pl[j] = x[4+j] & 0x7f
+	}
+	p.alg = x[8] & 31
+	p.fb = x[9] & 7
+	p.oks = x[9]&8 != 0
+	p.lfs = x[10] & 0x7f
+	p.lfd = x[11] & 0x7f
+	p.lpmd = x[12] & 0x7f
+	p.lamd = x[13] & 0x7f
+	p.lks = x[14]&1 != 0
+	p.lfw = (x[14] >> 1) & 7
+	p.lpms = (x[14] >> 4) & 7
+	p.transpose = x[15] & 0x7f
+	p.name = strings.TrimRight(string(x[16:26]), " \x00")
+	return p
+}
+
+// Decode a 32 voice bulk dump sysex bank.
+func decode_dx7_bank(buf []byte) ([]*DX7_Patch, error) {
+	n := dx7_bank_header_size + dx7_bank_data_size + 2
+	if len(buf) < n {
+		return nil, errors.New("bank is too short")
+	}
+	if buf[0] != 0xf0 || buf[1] != 0x43 || buf[2]&0xf0 != 0 || buf[3] != 0x09 ||
+		buf[4] != 0x20 || buf[5] != 0x00 {
+		return nil, errors.New("not a DX7 32 voice bulk dump")
+	}
+	data := buf[dx7_bank_header_size : dx7_bank_header_size+dx7_bank_data_size]
+	// the data bytes plus the checksum should sum to 0 (7 bits)
+	sum := buf[n-2]
+	for _, x := range data {
+		sum += x
+	}
+	if sum&0x7f != 0 {
+		return nil, errors.New("bad checksum")
+	}
+	patches := make([]*DX7_Patch, dx7_voices)
+	for i := range patches {
+		patches[i] = decode_dx7_voice(data[i*dx7_voice_size : (i+1)*dx7_voice_size])
+	}
+	return patches, nil
+}
+
+// Load a 32 voice bulk dump sysex bank from a file.
+func load_dx7_bank(path string) ([]*DX7_Patch, error) {
+	buf, err := ioutil.ReadFile(path)
+	if err != nil {
+		return nil, err
+	}
+	patches, err := decode_dx7_bank(buf)
+	if err != nil {
+		return nil, fmt.Errorf("%s: %s", path, err)
+	}
+	return patches, nil
+}
+
+//-----------------------------------------------------------------------------
+
+// Return the DX7 initial voice: a single sine carrier.
+func dx7_init_patch() *DX7_Patch {
+	p := &DX7_Patch{
+		name:      "INIT VOICE",
+		pr:        [4]uint8{99, 99, 99, 99},
+		pl:        [4]uint8{50, 50, 50, 50},
+		lfs:       35,
+		lks:       true,
+		oks:       true,
+		transpose: 24,
+	}
+	for i := range p.ops {
+		op := &p.ops[i]
+		op.rates = [4]uint8{99, 99, 99, 99}
+		op.levels = [4]uint8{99, 99, 99, 0}
+		op.bp = 39
+		op.fc = 1
+		op.detune = 7
+	}
+	p.ops[0].ol = 99
+	return p
+}
+
+//-----------------------------------------------------------------------------
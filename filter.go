@@ -0,0 +1,92 @@
+//-----------------------------------------------------------------------------
+/*
+
+Biquad Filters
+
+Second order IIR filters with coefficients from the RBJ Audio EQ Cookbook.
+
+*/
+//-----------------------------------------------------------------------------
+
+package main
+
+import "math"
+
+//-----------------------------------------------------------------------------
+
+type Biquad struct {
+	b0, b1, b2 float32 // feedforward coefficients
+	a1, a2     float32 // feedback coefficients
+	x1, x2     float32 // input state
+	y1, y2     float32 // output state
+}
+
+// Set the normalised coefficients.
+func (f *Biquad) set(b0, b1, b2, a0, a1, a2 float64) {
+	f.b0 = float32(b0 / a0)
+	f.b1 = float32(b1 / a0)
+	f.b2 = float32(b2 / a0)
+	f.a1 = float32(a1 / a0)
+	f.a2 = float32(a2 / a0)
+}
+
+// Return the filter angular frequency terms.
+func biquad_w(f, q float32, rate int) (cosw, alpha float64) {
+	w := 2.0 * math.Pi * float64(f) / float64(rate)
+	return math.Cos(w), math.Sin(w) / (2.0 * float64(q))
+}
+
+// Set a lowpass response.
+func (f *Biquad) SetLowPass(fc, q float32, rate int) {
+	c, a := biquad_w(fc, q, rate)
+	f.set((1.0-c)/2.0, 1.0-c, (1.0-c)/2.0, 1.0+a, -2.0*c, 1.0-a)
+}
+
+// Set a highpass response.
+func (f *Biquad) SetHighPass(fc, q float32, rate int) {
+	c, a := biquad_w(fc, q, rate)
+	f.set((1.0+c)/2.0, -(1.0 + c), (1.0+c)/2.0, 1.0+a, -2.0*c, 1.0-a)
+}
+
+// Set a bandpass response (0 dB peak gain).
+func (f *Biquad) SetBandPass(fc, q float32, rate int) {
+	c, a := biquad_w(fc, q, rate)
+	f.set(a, 0, -a, 1.0+a, -2.0*c, 1.0-a)
+}
+
+// Clear the filter state.
+func (f *Biquad) Clear() {
+	f.x1, f.x2, f.y1, f.y2 = 0, 0, 0, 0
+}
+
+// Filter an input sample.
+func (f *Biquad) Process(x float32) float32 {
+	y := (f.b0 * x) + (f.b1 * f.x1) + (f.b2 * f.x2) - (f.a1 * f.y1) - (f.a2 * f.y2)
+	f.x2 = f.x1
+	f.x1 = x
+	f.y2 = f.y1
+	f.y1 = y
+	return y
+}
+
+//-----------------------------------------------------------------------------
+
+func NewBiquad_LowPass(fc, q float32, rate int) *Biquad {
+	f := &Biquad{}
+	f.SetLowPass(fc, q, rate)
+	return f
+}
+
+func NewBiquad_HighPass(fc, q float32, rate int) *Biquad {
+	f := &Biquad{}
+	f.SetHighPass(fc, q, rate)
+	return f
+}
+
+func NewBiquad_BandPass(fc, q float32, rate int) *Biquad {
+	f := &Biquad{}
+	f.SetBandPass(fc, q, rate)
+	return f
+}
+
+//-----------------------------------------------------------------------------
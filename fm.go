@@ -0,0 +1,337 @@
+//-----------------------------------------------------------------------------
+/*
+
+6 Operator FM Synthesis
+
+A DX7 style FM voice. Each operator is a sine LUT with phase modulation
+inputs and a 4 stage rate/level envelope. The operators are connected using
+one of the 32 DX7 algorithms, with one feedback path per algorithm.
+
+The patch LFO, pitch envelope and keyboard level scaling are decoded but
+are not yet applied.
+
+*/
+//-----------------------------------------------------------------------------
+
+package main
+
+import "math"
+
+//-----------------------------------------------------------------------------
+// Rate/Level Envelope
+
+// Return the amplitude for a DX7 level (0..99).
+// Each level step is 0.75 dB.
+func dx7_level_to_amplitude(l float32) float32 {
+	if l <= 0 {
+		return 0
+	}
+	return float32(math.Pow(10.0, -0.75*float64(99.0-l)/20.0))
+}
+
+// Return the per sample level change for a DX7 rate (0..99).
+func dx7_rate_to_step(r float32, rate int) float32 {
+	// time in seconds for a full level sweep
+	t := 41.0 * math.Pow(2.0, -float64(r)/6.5)
+	return float32(99.0 / (t * float64(rate)))
+}
+
+type RLEnvelope struct {
+	steps  [4]float32 // per sample level change for each stage
+	levels [4]float32 // target level for each stage
+	state  ADSRState  // envelope state
+	level  float32    // current level (0..99)
+}
+
+// Return a rate/level envelope generator.
+// The attack, decay and sustain states move to levels 0, 1 and 2.
+// The release state moves to level 3.
+func NewRL_Envelope(
+	rates [4]uint8, // stage rates (0..99)
+	levels [4]uint8, // stage levels (0..99)
+	scale float32, // rate scaling (added to the rates)
+	rate int, // sample rate
+) *RLEnvelope {
+	e := &RLEnvelope{}
+	e.Set(rates, levels, scale, rate)
+	e.level = e.levels[3]
+	return e
+}
+
+// Set the rates and levels. The current level is kept.
+func (e *RLEnvelope) Set(rates [4]uint8, levels [4]uint8, scale float32, rate int) {
+	for i := range rates {
+		r := float32(rates[i]) + scale
+		if r > 99 {
+			r = 99
+		}
+		e.steps[i] = dx7_rate_to_step(r, rate)
+		e.levels[i] = float32(levels[i])
+	}
+}
+
+// Enter attack state.
+func (e *RLEnvelope) Attack() {
+	e.state = attack
+}
+
+// Enter release state.
+func (e *RLEnvelope) Release() {
+	if e.state != idle {
+		e.state = release
+	}
+}
+
+// Enter idle state.
+func (e *RLEnvelope) Idle() {
+	e.level = e.levels[3]
+	e.state = idle
+}
+
+// Move the level towards a target. Return true when the target is reached.
+func (e *RLEnvelope) move(i int) bool {
+	target := e.levels[i]
+	if e.level < target {
+		e.level += e.steps[i]
+		if e.level >= target {
+			e.level = target
+			return true
+		}
+		return false
+	}
+	e.level -= e.steps[i]
+	if e.level <= target {
+		e.level = target
+		return true
+	}
+	return false
+}
+
+// Return a sample value (amplitude) for the rate/level envelope.
+func (e *RLEnvelope) Sample() float32 {
+	switch e.state {
+	case idle:
+		// idle - do nothing
+	case attack:
+		if e.move(0) {
+			e.state = decay
+		}
+	case decay:
+		if e.move(1) {
+			e.state = sustain
+		}
+	case sustain:
+		// move to level 2 and hold
+		e.move(2)
+	case release:
+		if e.move(3) {
+			e.state = idle
+		}
+	default:
+		panic("bad envelope state")
+	}
+	return dx7_level_to_amplitude(e.level)
+}
+
+//-----------------------------------------------------------------------------
+// Algorithms
+
+const (
+	op1 = 1 << iota
+	op2
+	op3
+	op4
+	op5
+	op6
+)
+
+type fm_algorithm struct {
+	mod    [6]uint8 // operators modulating each operator
+	out    uint8    // carrier operators
+	fb_src int      // feedback from this operator
+	fb_dst int      // feedback to this operator
+}
+
+// The DX7 algorithms. Operators are indexed from 0.
+// Modulation always flows from higher to lower numbered operators.
+var fm_algorithms = [32]fm_algorithm{
+	{[6]uint8{op2, 0, op4, op5, op6, 0}, op1 | op3, 5, 5},                 // 1
+	{[6]uint8{op2, 0, op4, op5, op6, 0}, op1 | op3, 1, 1},                 // 2
+	{[6]uint8{op2, op3, 0, op5, op6, 0}, op1 | op4, 5, 5},                 // 3
+	{[6]uint8{op2, op3, 0, op5, op6, 0}, op1 | op4, 3, 5},                 // 4
+	{[6]uint8{op2, 0, op4, 0, op6, 0}, op1 | op3 | op5, 5, 5},             // 5
+	{[6]uint8{op2, 0, op4, 0, op6, 0}, op1 | op3 | op5, 4, 5},             // 6
+	{[6]uint8{op2, 0, op4 | op5, 0, op6, 0}, op1 | op3, 5, 5},             // 7
+	{[6]uint8{op2, 0, op4 | op5, 0, op6, 0}, op1 | op3, 3, 3},             // 8
+	{[6]uint8{op2, 0, op4 | op5, 0, op6, 0}, op1 | op3, 1, 1},             // 9
+	{[6]uint8{op2, op3, 0, op5 | op6, 0, 0}, op1 | op4, 2, 2},             // 10
+	{[6]uint8{op2, op3, 0, op5 | op6, 0, 0}, op1 | op4, 5, 5},             // 11
+	{[6]uint8{op2, 0, op4 | op5 | op6, 0, 0, 0}, op1 | op3, 1, 1},         // 12
+	{[6]uint8{op2, 0, op4 | op5 | op6, 0, 0, 0}, op1 | op3, 5, 5},         // 13
+	{[6]uint8{op2, 0, op4, op5 | op6, 0, 0}, op1 | op3, 5, 5},             // 14
+	{[6]uint8{op2, 0, op4, op5 | op6, 0, 0}, op1 | op3, 1, 1},             // 15
+	{[6]uint8{op2 | op3 | op5, 0, op4, 0, op6, 0}, op1, 5, 5},             // 16
+	{[6]uint8{op2 | op3 | op5, 0, op4, 0, op6, 0}, op1, 1, 1},             // 17
+	{[6]uint8{op2 | op3 | op4, 0, 0, op5, op6, 0}, op1, 2, 2},             // 18
+	{[6]uint8{op2, op3, 0, op6, op6, 0}, op1 | op4 | op5, 5, 5},           // 19
+	{[6]uint8{op3, op3, 0, op5 | op6, 0, 0}, op1 | op2 | op4, 2, 2},       // 20
+	{[6]uint8{op3, op3, 0, op6, op6, 0}, op1 | op2 | op4 | op5, 2, 2},     // 21
+	{[6]uint8{op2, 0, op6, op6, op6, 0}, op1 | op3 | op4 | op5, 5, 5},     // 22
+	{[6]uint8{0, op3, 0, op6, op6, 0}, op1 | op2 | op4 | op5, 5, 5},       // 23
+	{[6]uint8{0, 0, op6, op6, op6, 0}, op1 | op2 | op3 | op4 | op5, 5, 5}, // 24
+	{[6]uint8{0, 0, 0, op6, op6, 0}, op1 | op2 | op3 | op4 | op5, 5, 5},   // 25
+	{[6]uint8{0, op3, 0, op5 | op6, 0, 0}, op1 | op2 | op4, 5, 5},         // 26
+	{[6]uint8{0, op3, 0, op5 | op6, 0, 0}, op1 | op2 | op4, 2, 2},         // 27
+	{[6]uint8{op2, 0, op4, op5, 0, 0}, op1 | op3 | op6, 4, 4},             // 28
+	{[6]uint8{0, 0, op4, 0, op6, 0}, op1 | op2 | op3 | op5, 5, 5},         // 29
+	{[6]uint8{0, 0, op4, op5, 0, 0}, op1 | op2 | op3 | op6, 4, 4},         // 30
+	{[6]uint8{0, 0, 0, 0, op6, 0}, op1 | op2 | op3 | op4 | op5, 5, 5},     // 31
+	{[6]uint8{0, 0, 0, 0, 0, 0}, op1 | op2 | op3 | op4 | op5 | op6, 5, 5}, // 32
+}
+
+//-----------------------------------------------------------------------------
+// FM Voice
+
+type fm_operator struct {
+	osc   *LUT        // sine oscillator
+	env   *RLEnvelope // amplitude envelope
+	level float32     // output level
+	out   float32     // current output
+}
+
+type FM_Voice struct {
+	patch *DX7_Patch    // voice patch
+	alg   *fm_algorithm // operator connections
+	ops   [6]fm_operator
+	fb    float32    // feedback scale
+	fb_y  [2]float32 // feedback history
+	scale float32    // carrier output scale
+	rate  int        // sample rate
+}
+
+// Return a 6 operator FM voice for a DX7 patch.
+func NewFM_Voice(p *DX7_Patch, rate int) *FM_Voice {
+	v := &FM_Voice{
+		patch: p,
+		alg:   &fm_algorithms[p.alg&31],
+		rate:  rate,
+	}
+	if p.fb != 0 {
+		// feedback of 7 is +/- half a cycle
+		v.fb = float32(math.Pow(2.0, float64(p.fb)-8.0))
+	}
+	n := 0
+	for i := range v.ops {
+		v.ops[i].osc = NewLUT_Sine(0, rate)
+		v.ops[i].osc.SetFixed(true)
+		v.ops[i].env = NewRL_Envelope(p.ops[i].rates, p.ops[i].levels, 0, rate)
+		if v.alg.out&(1<<uint(i)) != 0 {
+			n++
+		}
+	}
+	v.scale = 1.0 / float32(n)
+	return v
+}
+
+// Return the frequency of an operator for a midi note.
+func (v *FM_Voice) op_frequency(op *DX7_Operator, note int) float32 {
+	var f float64
+	if op.fixed {
+		// 1, 10, 100, 1000 Hz scaled by up to ~x10
+		f = math.Pow(10.0, float64(op.fc&3)+float64(op.ff)/100.0)
+	} else {
+		f = float64(midi_to_frequency(uint(note)))
+		if op.fc == 0 {
+			f *= 0.5
+		} else {
+			f *= float64(op.fc)
+		}
+		f *= 1.0 + float64(op.ff)/100.0
+	}
+	// detune by up to +/- 7 cents
+	return float32(f * math.Pow(2.0, float64(int(op.detune)-7)/1200.0))
+}
+
+// Start a note.
+func (v *FM_Voice) NoteOn(note uint, velocity uint) {
+	p := v.patch
+	n := int(note) + int(p.transpose) - 24
+	if n < 0 {
+		n = 0
+	} else if n > 127 {
+		n = 127
+	}
+	for i := range v.ops {
+		op := &v.ops[i]
+		pop := &p.ops[i]
+		op.osc.SetStep(v.op_frequency(pop, n), v.rate)
+		if p.oks {
+			op.osc.phase = 0
+		}
+		// faster envelopes for higher notes
+		x := (n / 3) - 7
+		if x < 0 {
+			x = 0
+		} else if x > 31 {
+			x = 31
+		}
+		scale := float32(int(pop.rs)*x) / 8.0
+		op.env.Set(pop.rates, pop.levels, scale, v.rate)
+		op.env.Attack()
+		// key velocity sensitivity
+		k := float32(pop.kvs) / 7.0
+		vel := float32(velocity) / 127.0
+		op.level = dx7_level_to_amplitude(float32(pop.ol)) * (1.0 - k*(1.0-vel))
+	}
+	if p.oks {
+		v.fb_y[0] = 0
+		v.fb_y[1] = 0
+	}
+}
+
+// Release a note.
+func (v *FM_Voice) NoteOff() {
+	for i := range v.ops {
+		v.ops[i].env.Release()
+	}
+}
+
+// Return true if the voice is still sounding.
+func (v *FM_Voice) Active() bool {
+	for i := range v.ops {
+		if v.alg.out&(1<<uint(i)) != 0 && v.ops[i].env.state != idle {
+			return true
+		}
+	}
+	return false
+}
+
+// Return a sample value for the FM voice.
+func (v *FM_Voice) Sample() float32 {
+	alg := v.alg
+	var y float32
+	for i := 5; i >= 0; i-- {
+		op := &v.ops[i]
+		// sum the modulators (in cycles of phase)
+		var pm float32
+		for j := i + 1; j < 6; j++ {
+			if alg.mod[i]&(1<<uint(j)) != 0 {
+				pm += v.ops[j].out
+			}
+		}
+		if i == alg.fb_dst {
+			pm += v.fb * 0.5 * (v.fb_y[0] + v.fb_y[1])
+		}
+		op.out = op.osc.SampleMod(0, pm) * op.env.Sample() * op.level
+		if i == alg.fb_src {
+			v.fb_y[1] = v.fb_y[0]
+			v.fb_y[0] = op.out
+		}
+		if alg.out&(1<<uint(i)) != 0 {
+			y += op.out
+		}
+	}
+	return y * v.scale
+}
+
+//-----------------------------------------------------------------------------
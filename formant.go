@@ -0,0 +1,240 @@
+//-----------------------------------------------------------------------------
+/*
+
+Formant Synthesis
+
+A glottal pulse or sawtooth source shaped by five parallel bandpass formant
+resonators. The formants come from vowel presets (a, e, i, o, u) and the
+voice can morph smoothly between adjacent vowels.
+
+The glottal source is a Rosenberg pulse, differentiated to model the
+radiation at the lips.
+
+*/
+//-----------------------------------------------------------------------------
+
+package main
+
+import (
+	"errors"
+	"math"
+)
+
+//-----------------------------------------------------------------------------
+
+type FormantSource int
+
+const (
+	formant_glottal FormantSource = iota
+	formant_sawtooth
+)
+
+var formant_txt = map[FormantSource]string{
+	formant_glottal:  "glottal",
+	formant_sawtooth: "sawtooth",
+}
+
+func (x FormantSource) String() string {
+	return formant_txt[x]
+}
+
+//-----------------------------------------------------------------------------
+
+const num_formants = 5
+
+type Vowel struct {
+	f    [num_formants]float32 // formant frequencies (Hz)
+	bw   [num_formants]float32 // formant bandwidths (Hz)
+	gain [num_formants]float32 // formant gains (dB)
+}
+
+// bass voice formants
+var vowel_a = Vowel{
+	f:    [num_formants]float32{600, 1040, 2250, 2450, 2750},
+	bw:   [num_formants]float32{60, 70, 110, 120, 130},
+	gain: [num_formants]float32{0, -7, -9, -9, -20},
+}
+
+var vowel_e = Vowel{
+	f:    [num_formants]float32{400, 1620, 2400, 2800, 3100},
+	bw:   [num_formants]float32{40, 80, 100, 120, 120},
+	gain: [num_formants]float32{0, -12, -9, -12, -18},
+}
+
+var vowel_i = Vowel{
+	f:    [num_formants]float32{250, 1750, 2600, 3050, 3340},
+	bw:   [num_formants]float32{60, 90, 100, 120, 120},
+	gain: [num_formants]float32{0, -30, -16, -22, -28},
+}
+
+var vowel_o = Vowel{
+	f:    [num_formants]float32{400, 750, 2400, 2600, 2900},
+	bw:   [num_formants]float32{40, 80, 100, 120, 120},
+	gain: [num_formants]float32{0, -11, -21, -20, -40},
+}
+
+var vowel_u = Vowel{
+	f:    [num_formants]float32{350, 600, 2400, 2675, 2950},
+	bw:   [num_formants]float32{40, 80, 100, 120, 120},
+	gain: [num_formants]float32{0, -20, -32, -28, -36},
+}
+
+// vowels in morphing order
+var vowels = []*Vowel{&vowel_a, &vowel_e, &vowel_i, &vowel_o, &vowel_u}
+
+// Return the vowel k (0..1) of the way from v0 to v1.
+func vowel_lerp(v0, v1 *Vowel, k float32) Vowel {
+	var v Vowel
+	for i := 0; i < num_formants; i++ {
+		v.f[i] = v0.f[i] + (k * (v1.f[i] - v0.f[i]))
+		v.bw[i] = v0.bw[i] + (k * (v1.bw[i] - v0.bw[i]))
+		v.gain[i] = v0.gain[i] + (k * (v1.gain[i] - v0.gain[i]))
+	}
+	return v
+}
+
+//-----------------------------------------------------------------------------
+
+const glottal_open = 0.4   // glottal opening phase duration
+const glottal_close = 0.16 // glottal closing phase duration
+
+// Return the Rosenberg glottal flow at phase x (0..1).
+func glottal_pulse(x float32) float32 {
+	if x < glottal_open {
+		return 0.5 * (1.0 - float32(math.Cos(math.Pi*float64(x)/glottal_open)))
+	}
+	if x < glottal_open+glottal_close {
+		return float32(math.Cos(0.5 * math.Pi * float64(x-glottal_open) / glottal_close))
+	}
+	return 0
+}
+
+//-----------------------------------------------------------------------------
+
+type Formant struct {
+	source  FormantSource         // source waveform
+	saw     *BLEP                 // sawtooth source
+	x       float32               // glottal phase (0..1)
+	x1      float32               // previous glottal flow
+	filters [num_formants]Biquad  // formant resonators
+	gain    [num_formants]float32 // formant linear gains
+	env     *ADSR                 // amplitude envelope
+	vibrato *LUT                  // vibrato oscillator
+	vib     float32               // vibrato depth
+	f       float32               // source frequency
+	vel     float32               // velocity gain
+	rate    int                   // sample rate
+}
+
+// Return a formant voice.
+func NewFormant(source FormantSource, rate int) (*Formant, error) {
+	if source != formant_glottal && source != formant_sawtooth {
+		return nil, errors.New("bad formant source")
+	}
+	env, err := NewADSR_Envelope(0.08, 0.1, 0.9, 0.3, rate)
+	if err != nil {
+		return nil, err
+	}
+	v := &Formant{
+		source:  source,
+		saw:     NewBLEP_Sawtooth(110.0, rate),
+		env:     env,
+		vibrato: NewLUT_Sine(vibrato_frequency, rate),
+		f:       110.0,
+		rate:    rate,
+	}
+	v.SetVowel(&vowel_a)
+	return v, nil
+}
+
+// Set the formants of a vowel.
+func (v *Formant) SetVowel(vowel *Vowel) {
+	for i := range v.filters {
+		f := vowel.f[i]
+		v.filters[i].SetBandPass(f, f/vowel.bw[i], v.rate)
+		v.gain[i] = float32(math.Pow(10.0, float64(vowel.gain[i])/20.0))
+	}
+}
+
+// Set a morph position between the vowels: 0 is a, 1 is e, 2 is i, 3 is o, 4 is u.
+func (v *Formant) SetMorph(x float32) {
+	n := float32(len(vowels) - 1)
+	if x < 0 {
+		x = 0
+	} else if x > n {
+		x = n
+	}
+	i := int(x)
+	if i == len(vowels)-1 {
+		i--
+	}
+	vowel := vowel_lerp(vowels[i], vowels[i+1], x-float32(i))
+	v.SetVowel(&vowel)
+}
+
+// Set the vibrato depth (fraction of the frequency).
+func (v *Formant) SetVibrato(depth float32) {
+	v.vib = depth
+}
+
+// Set the amplitude envelope.
+func (v *Formant) SetEnvelope(a, d, s, r float32) error {
+	env, err := NewADSR_Envelope(a, d, s, r, v.rate)
+	if err != nil {
+		return err
+	}
+	v.env = env
+	return nil
+}
+
+//-----------------------------------------------------------------------------
+
+func (v *Formant) NoteOn(note uint, velocity uint) {
+	v.f = midi_to_frequency(note)
+	v.vel = cc_to_float(velocity)
+	v.env.Attack()
+}
+
+func (v *Formant) NoteOff() {
+	v.env.Release()
+}
+
+func (v *Formant) Active() bool {
+	return v.env.state != idle
+}
+
+// The modulation wheel sets the vibrato depth, brightness morphs the vowel.
+func (v *Formant) ControlChange(cc uint, val uint) {
+	switch cc {
+	case cc_modulation:
+		v.SetVibrato(0.02 * cc_to_float(val))
+	case cc_brightness:
+		v.SetMorph(float32(len(vowels)-1) * cc_to_float(val))
+	}
+}
+
+func (v *Formant) Sample() float32 {
+	f := v.f * (1.0 + (v.vib * v.vibrato.Sample()))
+	var x float32
+	switch v.source {
+	case formant_glottal:
+		g := glottal_pulse(v.x)
+		// differentiate for the lip radiation
+		x = 0.15 * (g - v.x1) * float32(v.rate) / f
+		v.x1 = g
+		v.x += f / float32(v.rate)
+		if v.x >= 1.0 {
+			v.x -= 1.0
+		}
+	case formant_sawtooth:
+		v.saw.SetStep(f, v.rate)
+		x = v.saw.Sample()
+	}
+	var y float32
+	for i := range v.filters {
+		y += v.gain[i] * v.filters[i].Process(x)
+	}
+	return 2.0 * y * v.vel * v.env.Sample()
+}
+
+//-----------------------------------------------------------------------------
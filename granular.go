@@ -0,0 +1,245 @@
+//-----------------------------------------------------------------------------
+/*
+
+Granular Synthesis
+
+Grains are short Hann windowed segments read from a source buffer. The
+source is either a fixed sample buffer or a ring buffer of live input.
+The grain size, density (grains per second), position in the source, pitch
+and randomisation of each are all controllable.
+
+Grains run from a fixed size pool, so there is no allocation in the audio
+path. If the pool is full new grains are dropped.
+
+*/
+//-----------------------------------------------------------------------------
+
+package main
+
+import (
+	"errors"
+	"math"
+)
+
+//-----------------------------------------------------------------------------
+
+const max_grains = 64 // size of the grain pool
+
+type grain struct {
+	active bool
+	pos    float64 // position in the source buffer
+	inc    float64 // position increment per sample
+	n      int     // grain length in samples
+	i      int     // sample index within the grain
+	gl, gr float32 // left/right gain
+}
+
+type Granular struct {
+	buf     []float32 // source buffer
+	live    bool      // the source is a ring buffer of live input
+	wr      int       // live input write index
+	grains  [max_grains]grain
+	rng     PRNG    // randomisation
+	size    float32 // grain size in seconds
+	density float32 // grains per second
+	pos     float32 // position in the source (0..1)
+	pitch   float32 // playback rate
+	rpos    float32 // position randomisation (0..1)
+	rpitch  float32 // pitch randomisation in semitones
+	rsize   float32 // size randomisation (0..1)
+	spread  float32 // stereo spread (0..1)
+	next    float32 // samples until the next grain
+	rate    int     // sample rate
+}
+
+// Return a granular engine reading from a sample buffer.
+func NewGranular(buf []float32, rate int) (*Granular, error) {
+	if len(buf) < 2 {
+		return nil, errors.New("source buffer is too short")
+	}
+	g := &Granular{
+		buf:     buf,
+		size:    0.05,
+		density: 20,
+		pitch:   1.0,
+		rate:    rate,
+	}
+	g.rng.Seed(1)
+	return g, nil
+}
+
+// Return a granular engine reading from a live input buffer of t seconds.
+func NewGranular_Live(t float32, rate int) (*Granular, error) {
+	g, err := NewGranular(make([]float32, int(t*float32(rate))), rate)
+	if err != nil {
+		return nil, err
+	}
+	g.live = true
+	return g, nil
+}
+
+//-----------------------------------------------------------------------------
+
+// Set the grain size in seconds.
+func (g *Granular) SetSize(t float32) {
+	g.size = t
+}
+
+// Set the grain density in grains per second.
+func (g *Granular) SetDensity(d float32) {
+	g.density = d
+}
+
+// Set the position in the source (0..1).
+// For live input this is how far back from the most recent input.
+func (g *Granular) SetPosition(p float32) {
+	g.pos = p
+}
+
+// Set the grain playback rate (1 is the original pitch).
+func (g *Granular) SetPitch(k float32) {
+	g.pitch = k
+}
+
+// Set the randomisation of position (0..1), pitch (semitones) and size (0..1).
+func (g *Granular) SetRandom(pos, pitch, size float32) {
+	g.rpos = pos
+	g.rpitch = pitch
+	g.rsize = size
+}
+
+// Set the stereo spread (0..1) of the grains.
+func (g *Granular) SetSpread(spread float32) {
+	g.spread = spread
+}
+
+// Write a live input sample.
+func (g *Granular) Write(x float32) {
+	g.buf[g.wr] = x
+	g.wr++
+	if g.wr == len(g.buf) {
+		g.wr = 0
+	}
+}
+
+//-----------------------------------------------------------------------------
+
+// Start a new grain.
+func (g *Granular) spawn() {
+	var gr *grain
+	for i := range g.grains {
+		if !g.grains[i].active {
+			gr = &g.grains[i]
+			break
+		}
+	}
+	if gr == nil {
+		// the pool is full
+		return
+	}
+	n := float32(len(g.buf))
+	size := g.size * (1.0 + (g.rsize * g.rng.Bipolar()))
+	gr.n = int(size * float32(g.rate))
+	if gr.n < 2 {
+		return
+	}
+	gr.inc = float64(g.pitch) * math.Pow(2.0, float64(g.rpitch*g.rng.Bipolar())/12.0)
+	pos := g.pos + (g.rpos * g.rng.Bipolar())
+	if pos < 0 {
+		pos = 0
+	} else if pos > 1.0 {
+		pos = 1.0
+	}
+	if g.live {
+		// read back from the write index, far enough not to overtake it
+		back := (pos * (n - 1.0)) + float32(float64(gr.n)*gr.inc)
+		gr.pos = float64(g.wr) - float64(back)
+	} else {
+		gr.pos = float64(pos * (n - 1.0))
+	}
+	// random stereo position with equal power panning
+	a := float64((g.spread*g.rng.Bipolar())+1.0) * math.Pi / 4.0
+	gr.gl = float32(math.Cos(a))
+	gr.gr = float32(math.Sin(a))
+	gr.i = 0
+	gr.active = true
+}
+
+// Return the source buffer value at a fractional position.
+// A live buffer wraps, a fixed buffer is silent outside the sample.
+func (g *Granular) read(pos float64) float32 {
+	n := float64(len(g.buf))
+	if g.live {
+		pos -= n * math.Floor(pos/n)
+	} else if pos < 0 || pos > n-1.0 {
+		return 0
+	}
+	x0 := int(pos)
+	if x0 >= len(g.buf) {
+		// float rounding
+		x0 = 0
+	}
+	return table_interpolate(g.buf, x0, float32(pos-float64(x0)), interp_linear)
+}
+
+// Return a stereo sample.
+func (g *Granular) SampleStereo() (float32, float32) {
+	// schedule grains
+	if g.density > 0 {
+		g.next -= 1.0
+		for g.next <= 0 {
+			g.spawn()
+			g.next += float32(g.rate) / g.density
+		}
+	}
+	var l, r float32
+	for i := range g.grains {
+		gr := &g.grains[i]
+		if !gr.active {
+			continue
+		}
+		// hann window
+		w := 0.5 * (1.0 - g.cos(float32(gr.i)/float32(gr.n)))
+		y := w * g.read(gr.pos)
+		l += y * gr.gl
+		r += y * gr.gr
+		gr.pos += gr.inc
+		gr.i++
+		if gr.i == gr.n {
+			gr.active = false
+		}
+	}
+	// normalise for the average number of overlapping grains
+	k := g.density * g.size
+	if k > 1.0 {
+		k = float32(1.0 / math.Sqrt(float64(k)))
+		l *= k
+		r *= k
+	}
+	return l, r
+}
+
+// Return a mono sample.
+func (g *Granular) Sample() float32 {
+	l, r := g.SampleStereo()
+	return (l + r) * math.Sqrt2 / 2.0
+}
+
+// Fill a buffer with mono samples.
+func (g *Granular) Render(out []float32) {
+	for i := range out {
+		out[i] = g.Sample()
+	}
+}
+
+// Return the cosine of a phase (0..1 is one cycle).
+func (g *Granular) cos(x float32) float32 {
+	x *= float32(len(cos_table))
+	x0 := int(x)
+	if x0 >= len(cos_table) {
+		x0 = 0
+	}
+	return table_interpolate(cos_table, x0, x-float32(x0), interp_linear)
+}
+
+//-----------------------------------------------------------------------------
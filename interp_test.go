@@ -0,0 +1,65 @@
+//-----------------------------------------------------------------------------
+/*
+
+Table Interpolation Tests
+
+*/
+//-----------------------------------------------------------------------------
+
+package main
+
+import (
+	"math"
+	"testing"
+)
+
+//-----------------------------------------------------------------------------
+
+// Return the THD+N (as a ratio) of a LUT sine oscillator at about 1 kHz.
+func lut_thd(mode Interpolation, rate int) float64 {
+	// Use a whole number of cycles in a power of 2 sample window so the
+	// frequency is exact for the fixed point phase accumulator.
+	const n = 1 << 16
+	const cycles = 1487
+	f := float64(rate) * cycles / n
+	t := NewLUT_Sine(float32(f), rate)
+	t.dphase = cycles * (phase_one / n)
+	t.SetFixed(true)
+	t.SetInterpolation(mode)
+	w := 2.0 * math.Pi * cycles / n
+	var a, b, dc, total float64
+	for i := 0; i < n; i++ {
+		y := float64(t.Sample())
+		a += y * math.Cos(w*float64(i))
+		b += y * math.Sin(w*float64(i))
+		dc += y
+		total += y * y
+	}
+	// signal power at the fundamental
+	a *= 2.0 / n
+	b *= 2.0 / n
+	fundamental := (a*a + b*b) / 2.0
+	// everything else is distortion and noise
+	dc /= n
+	total = (total / n) - (dc * dc)
+	return math.Sqrt((total - fundamental) / fundamental)
+}
+
+// Check the THD+N for each interpolation mode against the figures in interp.go.
+func TestLUT_THD(t *testing.T) {
+	limit := map[Interpolation]float64{
+		interp_truncate: -48.0,
+		interp_linear:   -104.0,
+		interp_hermite:  -130.0,
+		interp_lagrange: -130.0,
+	}
+	for _, mode := range []Interpolation{interp_truncate, interp_linear, interp_hermite, interp_lagrange} {
+		db := 20.0 * math.Log10(lut_thd(mode, SAMPLE_RATE))
+		t.Logf("%-8s %.1f dB", mode, db)
+		if db > limit[mode] {
+			t.Errorf("%s: THD+N %.1f dB is above %.1f dB", mode, db, limit[mode])
+		}
+	}
+}
+
+//-----------------------------------------------------------------------------
@@ -46,11 +46,23 @@ func init() {
 
 //-----------------------------------------------------------------------------
 
+// The LUT phase is kept either as a float32 table position, or as a 32-bit
+// fixed point phase accumulator where 2^32 is one cycle. The fixed point mode
+// has exact frequency resolution and doesn't drift over long periods.
+const phase_one = 1 << 32
+
 type LUT struct {
 	table  []float32
 	xrange float32
 	x      float32
 	step   float32
+	fixed  bool   // use the fixed point phase accumulator
+	phase  uint32 // fixed point phase
+	dphase uint32 // fixed point phase step
+	interp Interpolation
+	kx     float32 // table step per Hz of frequency modulation
+	kphase float64 // fixed point phase step per Hz of frequency modulation
+	wrap   bool    // the phase wrapped on the last sample
 }
 
 func (t *LUT) SetTable(table []float32) {
@@ -59,26 +71,131 @@ func (t *LUT) SetTable(table []float32) {
 }
 
 func (t *LUT) SetStep(f float32, rate int) {
-	t.step = f * float32(len(t.table)) / float32(rate)
+	t.step = float32(float64(f) * float64(len(t.table)) / float64(rate))
+	t.dphase = uint32(math.Floor((float64(f) * phase_one / float64(rate)) + 0.5))
+	t.kx = float32(len(t.table)) / float32(rate)
+	t.kphase = phase_one / float64(rate)
 }
 
-func (t *LUT) Sample() float32 {
-	// linear interpolation
-	x0 := int(math.Floor(float64(t.x)))
-	y0 := t.table[x0]
-	var y1 float32
-	if x0 == len(t.table)-1 {
-		y1 = t.table[0]
+// Select the fixed point (or floating point) phase accumulator.
+func (t *LUT) SetFixed(fixed bool) {
+	if fixed == t.fixed {
+		return
+	}
+	// carry the current phase across
+	if fixed {
+		t.phase = uint32(float64(t.x) * phase_one / float64(t.xrange))
 	} else {
-		y1 = t.table[x0+1]
+		t.x = float32(float64(t.phase) * float64(t.xrange) / phase_one)
+		if t.x >= t.xrange {
+			// float32 rounding
+			t.x -= t.xrange
+		}
 	}
-	y := y0 + ((t.x - float32(x0)) * (y1 - y0))
-	// step the x position
-	t.x += t.step
+	t.fixed = fixed
+}
+
+// Set the phase (0..1 is one cycle).
+func (t *LUT) SetPhase(p float32) {
+	p -= float32(math.Floor(float64(p)))
+	t.phase = uint32(uint64(float64(p) * phase_one))
+	t.x = p * t.xrange
 	if t.x >= t.xrange {
-		t.x -= t.xrange
+		// float32 rounding
+		t.x = 0
+	}
+}
+
+// Return true if the phase wrapped around on the last sample.
+func (t *LUT) Wrapped() bool {
+	return t.wrap
+}
+
+// Select the table interpolation mode.
+func (t *LUT) SetInterpolation(mode Interpolation) {
+	t.interp = mode
+}
+
+func (t *LUT) Sample() float32 {
+	var x0 int
+	var dx float32
+	if t.fixed {
+		// the upper 32 bits are the table index, the lower 32 bits the fraction
+		p := uint64(t.phase) * uint64(len(t.table))
+		x0 = int(p >> 32)
+		dx = float32(uint32(p)) / phase_one
+		// step the phase, wrapping is implicit
+		p0 := t.phase
+		t.phase += t.dphase
+		t.wrap = t.phase < p0
+	} else {
+		x0 = int(t.x)
+		dx = t.x - float32(x0)
+		// step the x position
+		t.x += t.step
+		t.wrap = t.x >= t.xrange
+		if t.wrap {
+			t.x -= t.xrange
+		}
+	}
+	return table_interpolate(t.table, x0, dx, t.interp)
+}
+
+// Return a sample with a frequency offset fm (Hz) and a phase offset pm (cycles).
+// The frequency offset may take the frequency through zero.
+func (t *LUT) SampleMod(fm, pm float32) float32 {
+	var x0 int
+	var dx float32
+	// phase offset within a cycle
+	pm -= float32(math.Floor(float64(pm)))
+	if t.fixed {
+		p := t.phase + uint32(uint64(float64(pm)*phase_one))
+		// the upper 32 bits are the table index, the lower 32 bits the fraction
+		q := uint64(p) * uint64(len(t.table))
+		x0 = int(q >> 32)
+		dx = float32(uint32(q)) / phase_one
+		// step the phase, wrapping is implicit
+		p0 := t.phase
+		d := int64(t.dphase) + int64(float64(fm)*t.kphase)
+		t.phase += uint32(d)
+		t.wrap = (d >= 0 && t.phase < p0) || (d < 0 && t.phase > p0)
+	} else {
+		x := t.x + (pm * t.xrange)
+		if x >= t.xrange {
+			x -= t.xrange
+		}
+		x0 = int(x)
+		if x0 == len(t.table) {
+			// float32 rounding
+			x0 = 0
+		}
+		dx = x - float32(x0)
+		// step the x position
+		t.x += t.step + (fm * t.kx)
+		t.wrap = t.x >= t.xrange || t.x < 0
+		if t.wrap {
+			t.x -= t.xrange * float32(math.Floor(float64(t.x/t.xrange)))
+			if t.x >= t.xrange {
+				t.x = 0
+			}
+		}
+	}
+	return table_interpolate(t.table, x0, dx, t.interp)
+}
+
+// Fill a buffer with samples using per sample frequency (Hz) and phase (cycles)
+// modulation buffers. Either modulation buffer may be nil.
+func (t *LUT) Modulate(out, fm, pm []float32) {
+	for i := range out {
+		var f, p float32
+		if fm != nil {
+			f = fm[i]
+		}
+		if pm != nil {
+			p = pm[i]
+		}
+		out[i] = t.SampleMod(f, p)
 	}
-	return y
 }
 
 //-----------------------------------------------------------------------------
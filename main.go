@@ -43,9 +43,15 @@ func sine_wave(pa *pulsego.PulseMainLoop) {
 	t0 := NewLUT_Sine(midi_to_frequency(chord[0]), SAMPLE_RATE)
 	t1 := NewLUT_Sine(midi_to_frequency(chord[1]), SAMPLE_RATE)
 	t2 := NewLUT_Sine(midi_to_frequency(chord[2]), SAMPLE_RATE)
+	// keep the chord phase coherent over long periods
+	t0.SetFixed(true)
+	t1.SetFixed(true)
+	t2.SetFixed(true)
 
 	//t := NewLUT_Sawtooth(440.0, SAMPLE_RATE)
 	//t := NewLUT_Square(440.0, SAMPLE_RATE)
+	//t := NewBLEP_Sawtooth(440.0, SAMPLE_RATE)
+	//t := NewBLEP_Square(440.0, SAMPLE_RATE)
 
 	for {
 		for i, _ := range samples {
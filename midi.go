@@ -33,6 +33,22 @@ func midi_to_octave(note uint) uint {
 
 //-----------------------------------------------------------------------------
 
+const cc_modulation = 1
+const cc_breath = 2
+const cc_expression = 11
+const cc_resonance = 71
+const cc_brightness = 74
+
+// return a midi cc value (0..127) as 0..1
+func cc_to_float(val uint) float32 {
+	if val > 127 {
+		val = 127
+	}
+	return float32(val) / 127.0
+}
+
+//-----------------------------------------------------------------------------
+
 func major_chord(root uint) [3]uint {
 	return [3]uint{root, root + 4, root + 7}
 }
@@ -0,0 +1,200 @@
+//-----------------------------------------------------------------------------
+/*
+
+Modal Synthesis
+
+A bank of tuned two-pole resonators. Each resonator is a mode of a vibrating
+object with a frequency ratio (to the fundamental), a decay time and a gain.
+The bank is excited by an impulse, a noise burst or external audio.
+
+*/
+//-----------------------------------------------------------------------------
+
+package main
+
+import (
+	"errors"
+	"math"
+)
+
+//-----------------------------------------------------------------------------
+
+type Mode struct {
+	ratio float32 // frequency ratio to the fundamental
+	decay float32 // decay time in seconds (to -60 dB)
+	gain  float32 // mode gain
+}
+
+// two-pole resonator
+type resonator struct {
+	b0     float32 // input gain
+	a1, a2 float32 // feedback coefficients
+	y1, y2 float32 // state
+}
+
+func (r *resonator) set(f, decay float32, rate int) {
+	w := 2.0 * math.Pi * float64(f) / float64(rate)
+	k := math.Pow(0.001, 1.0/(float64(decay)*float64(rate)))
+	r.a1 = float32(2.0 * k * math.Cos(w))
+	r.a2 = float32(k * k)
+	// normalise the impulse response to unit amplitude
+	r.b0 = float32(math.Sin(w))
+}
+
+func (r *resonator) tick(x float32) float32 {
+	y := (r.b0 * x) + (r.a1 * r.y1) - (r.a2 * r.y2)
+	r.y2 = r.y1
+	r.y1 = y
+	return y
+}
+
+//-----------------------------------------------------------------------------
+
+type Modal struct {
+	modes   []Mode
+	res     []resonator
+	audible []bool  // is the mode below nyquist?
+	noise   *Noise  // noise burst excitation
+	n       int     // remaining noise burst samples
+	amp     float32 // excitation amplitude
+	impulse float32 // pending impulse excitation
+	k       float32 // decay time scale
+	gain    float32 // output normalisation
+	f       float32 // fundamental frequency
+	rate    int     // sample rate
+}
+
+// Return a modal resonator bank.
+func NewModal(modes []Mode, rate int) (*Modal, error) {
+	if len(modes) == 0 {
+		return nil, errors.New("no modes")
+	}
+	m := &Modal{
+		modes:   modes,
+		res:     make([]resonator, len(modes)),
+		audible: make([]bool, len(modes)),
+		noise:   NewNoise_White(1),
+		k:       1.0,
+		rate:    rate,
+	}
+	var sum float32
+	for _, mode := range modes {
+		if mode.ratio <= 0 || mode.decay <= 0 {
+			return nil, errors.New("bad mode")
+		}
+		sum += mode.gain
+	}
+	if sum != 0 {
+		m.gain = 1.0 / sum
+	}
+	m.SetFrequency(440.0)
+	return m, nil
+}
+
+//-----------------------------------------------------------------------------
+
+// Set the fundamental frequency.
+func (m *Modal) SetFrequency(f float32) {
+	m.f = f
+	nyquist := float32(m.rate) / 2.0
+	for i, mode := range m.modes {
+		fm := f * mode.ratio
+		m.audible[i] = fm < nyquist
+		if m.audible[i] {
+			m.res[i].set(fm, mode.decay*m.k, m.rate)
+		}
+	}
+}
+
+// Scale the decay times of all modes.
+func (m *Modal) SetDecay(k float32) {
+	m.k = k
+	m.SetFrequency(m.f)
+}
+
+// Excite the modes with an impulse.
+func (m *Modal) Strike(velocity uint) {
+	m.impulse += cc_to_float(velocity)
+}
+
+// Excite the modes with a burst of noise.
+func (m *Modal) StrikeNoise(velocity uint, t float32) {
+	m.amp = cc_to_float(velocity) * 0.25
+	m.n = int(t * float32(m.rate))
+}
+
+// Set the frequency and strike the modes.
+func (m *Modal) NoteOn(note uint, velocity uint) {
+	m.SetFrequency(midi_to_frequency(note))
+	m.Strike(velocity)
+}
+
+// Excite the modes with an external input sample and return the output.
+func (m *Modal) Process(x float32) float32 {
+	x += m.impulse
+	m.impulse = 0
+	if m.n > 0 {
+		x += m.amp * m.noise.Sample()
+		m.n--
+	}
+	var y float32
+	for i := range m.res {
+		if m.audible[i] {
+			y += m.modes[i].gain * m.res[i].tick(x)
+		}
+	}
+	return y * m.gain
+}
+
+func (m *Modal) Sample() float32 {
+	return m.Process(0)
+}
+
+//-----------------------------------------------------------------------------
+// presets
+
+func modes_marimba() []Mode {
+	return []Mode{
+		{1.0, 1.2, 1.0},
+		{3.99, 0.35, 0.4},
+		{10.65, 0.12, 0.2},
+	}
+}
+
+func modes_glockenspiel() []Mode {
+	return []Mode{
+		{1.0, 3.0, 1.0},
+		{2.71, 1.8, 0.6},
+		{5.15, 1.0, 0.4},
+		{8.43, 0.6, 0.25},
+	}
+}
+
+func modes_tubular_bell() []Mode {
+	return []Mode{
+		{1.0, 6.0, 0.6},
+		{2.76, 5.0, 1.0},
+		{5.40, 4.0, 0.8},
+		{8.93, 3.0, 0.6},
+		{13.34, 2.0, 0.4},
+		{18.64, 1.2, 0.2},
+	}
+}
+
+func modes_metal_plate() []Mode {
+	return []Mode{
+		{1.0, 2.5, 1.0},
+		{1.59, 2.2, 0.8},
+		{2.14, 2.0, 0.7},
+		{2.30, 1.8, 0.7},
+		{2.65, 1.6, 0.6},
+		{2.92, 1.4, 0.5},
+		{3.16, 1.2, 0.5},
+		{3.50, 1.0, 0.4},
+		{3.60, 1.0, 0.4},
+		{4.06, 0.8, 0.3},
+		{4.15, 0.8, 0.3},
+	}
+}
+
+//-----------------------------------------------------------------------------
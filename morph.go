@@ -0,0 +1,123 @@
+//-----------------------------------------------------------------------------
+/*
+
+Wavetable Morphing Oscillator
+
+The oscillator holds N frames of single cycle waveforms (E.g. 64 frames of
+2048 samples). A position control scans through the frames and the output
+is interpolated between the two adjacent frames. Modulating the position
+gives PPG/Serum style evolving timbres.
+
+*/
+//-----------------------------------------------------------------------------
+
+package main
+
+import (
+	"errors"
+	"math"
+)
+
+//-----------------------------------------------------------------------------
+
+type Morph struct {
+	frames [][]float32 // single cycle frames
+	xrange float32     // frame size
+	x      float32     // frame position
+	step   float32     // frame step per sample
+	f0     int         // first frame to interpolate
+	k      float32     // interpolation amount from frame f0 to f0+1
+}
+
+// Return a morphing wavetable oscillator for a set of frames.
+func NewMorph(frames [][]float32, f float32, rate int) (*Morph, error) {
+	if len(frames) == 0 {
+		return nil, errors.New("no wavetable frames")
+	}
+	n := len(frames[0])
+	if n == 0 {
+		return nil, errors.New("empty wavetable frame")
+	}
+	for _, frame := range frames {
+		if len(frame) != n {
+			return nil, errors.New("wavetable frames have different sizes")
+		}
+	}
+	t := &Morph{
+		frames: frames,
+		xrange: float32(n),
+	}
+	t.SetStep(f, rate)
+	return t, nil
+}
+
+//-----------------------------------------------------------------------------
+
+func (t *Morph) SetStep(f float32, rate int) {
+	t.step = f * t.xrange / float32(rate)
+}
+
+// Set the position (0..1) within the frames.
+func (t *Morph) SetPosition(p float32) {
+	if p < 0 {
+		p = 0
+	} else if p > 1 {
+		p = 1
+	}
+	n := len(t.frames) - 1
+	p *= float32(n)
+	t.f0 = int(p)
+	if t.f0 == n {
+		// the last frame
+		t.f0 = n - 1
+		if t.f0 < 0 {
+			t.f0 = 0
+		}
+	}
+	t.k = p - float32(t.f0)
+}
+
+func (t *Morph) Sample() float32 {
+	t0 := t.frames[t.f0]
+	t1 := t0
+	if t.f0+1 < len(t.frames) {
+		t1 = t.frames[t.f0+1]
+	}
+	// linear interpolation
+	x0 := int(t.x)
+	x1 := x0 + 1
+	if x1 == len(t0) {
+		x1 = 0
+	}
+	dx := t.x - float32(x0)
+	y0 := t0[x0] + (dx * (t0[x1] - t0[x0]))
+	y1 := t1[x0] + (dx * (t1[x1] - t1[x0]))
+	// interpolate between frames
+	y := y0 + (t.k * (y1 - y0))
+	// step the x position
+	t.x += t.step
+	if t.x >= t.xrange {
+		t.x -= t.xrange
+	}
+	return y
+}
+
+//-----------------------------------------------------------------------------
+
+// Return n frames of a given size that morph from a sine to a sawtooth.
+func sine_to_sawtooth_frames(n, size int) [][]float32 {
+	frames := make([][]float32, n)
+	saw := sawtooth_spectrum(size / 2)
+	for i := range frames {
+		// bring in the upper harmonics exponentially as the frame number increases
+		h := 1
+		if n > 1 {
+			h = int(math.Pow(float64(len(saw)), float64(i)/float64(n-1)))
+		}
+		frames[i] = harmonic_table(saw, h, size)
+	}
+	normalise_tables(frames, frames[n-1])
+	return frames
+}
+
+//-----------------------------------------------------------------------------
@@ -0,0 +1,689 @@
+//-----------------------------------------------------------------------------
+/*
+
+NES 2A03 APU Emulation
+
+A register level emulation of the NES sound hardware: two pulse channels,
+a triangle channel, a noise channel and the delta modulation channel (DMC).
+The APU is clocked at the NTSC CPU rate and the output of each CPU cycle is
+averaged down to the sample rate. The channels are combined with the
+non-linear mixer of the real hardware.
+
+The channels can be driven from MIDI with NES voices. Each voice writes the
+registers of its channel. DMC samples are read from the memory at $C000.
+
+The frame counter IRQ and DMC IRQ are not emulated.
+
+*/
+//-----------------------------------------------------------------------------
+
+package main
+
+import "errors"
+
+//-----------------------------------------------------------------------------
+
+const nes_clock = 1789773 // NTSC cpu clock
+
+// length counter load values
+var nes_length = [32]uint8{
+	10, 254, 20, 2, 40, 4, 80, 6, 160, 8, 60, 10, 14, 12, 26, 14,
+	12, 16, 24, 18, 48, 20, 96, 22, 192, 24, 72, 26, 16, 28, 32, 30,
+}
+
+// pulse duty cycle sequences
+var nes_duty = [4][8]uint8{
+	{0, 1, 0, 0, 0, 0, 0, 0},
+	{0, 1, 1, 0, 0, 0, 0, 0},
+	{0, 1, 1, 1, 1, 0, 0, 0},
+	{1, 0, 0, 1, 1, 1, 1, 1},
+}
+
+// triangle sequence
+var nes_triangle_seq = [32]uint8{
+	15, 14, 13, 12, 11, 10, 9, 8, 7, 6, 5, 4, 3, 2, 1, 0,
+	0, 1, 2, 3, 4, 5, 6, 7, 8, 9, 10, 11, 12, 13, 14, 15,
+}
+
+// noise timer periods in cpu cycles
+var nes_noise_period = [16]uint16{
+	4, 8, 16, 32, 64, 96, 128, 160, 202, 254, 380, 508, 762, 1016, 2034, 4068,
+}
+
+// dmc timer periods in cpu cycles
+var nes_dmc_period = [16]uint16{
+	428, 380, 340, 320, 286, 254, 226, 214, 190, 160, 142, 128, 106, 84, 72, 54,
+}
+
+//-----------------------------------------------------------------------------
+// envelope and length counter shared by the pulse and noise channels
+
+type nes_envelope struct {
+	start    bool
+	loop     bool  // loop the decay (also halts the length counter)
+	constant bool  // constant volume
+	volume   uint8 // constant volume or decay period
+	divider  uint8
+	decay    uint8
+}
+
+func (e *nes_envelope) write(val uint8) {
+	e.loop = val&0x20 != 0
+	e.constant = val&0x10 != 0
+	e.volume = val & 15
+}
+
+// clocked on a quarter frame
+func (e *nes_envelope) clock() {
+	if e.start {
+		e.start = false
+		e.decay = 15
+		e.divider = e.volume
+		return
+	}
+	if e.divider > 0 {
+		e.divider--
+		return
+	}
+	e.divider = e.volume
+	if e.decay > 0 {
+		e.decay--
+	} else if e.loop {
+		e.decay = 15
+	}
+}
+
+func (e *nes_envelope) output() uint8 {
+	if e.constant {
+		return e.volume
+	}
+	return e.decay
+}
+
+type nes_length_counter struct {
+	enabled bool
+	halt    bool
+	count   uint8
+}
+
+func (l *nes_length_counter) load(val uint8) {
+	if l.enabled {
+		l.count = nes_length[val>>3]
+	}
+}
+
+func (l *nes_length_counter) enable(on bool) {
+	l.enabled = on
+	if !on {
+		l.count = 0
+	}
+}
+
+// clocked on a half frame
+func (l *nes_length_counter) clock() {
+	if !l.halt && l.count > 0 {
+		l.count--
+	}
+}
+
+//-----------------------------------------------------------------------------
+
+type nes_pulse_channel struct {
+	env          nes_envelope
+	length       nes_length_counter
+	duty         uint8
+	seq          uint8  // sequencer step
+	period       uint16 // timer period
+	timer        uint16
+	sweep_on     bool
+	sweep_negate bool
+	sweep_period uint8
+	sweep_shift  uint8
+	sweep_div    uint8
+	sweep_reload bool
+	ones         bool // pulse 1 negates with ones complement
+}
+
+func (p *nes_pulse_channel) write(reg int, val uint8) {
+	switch reg {
+	case 0:
+		p.duty = val >> 6
+		p.env.write(val)
+		p.length.halt = val&0x20 != 0
+	case 1:
+		p.sweep_on = val&0x80 != 0
+		p.sweep_period = (val >> 4) & 7
+		p.sweep_negate = val&0x08 != 0
+		p.sweep_shift = val & 7
+		p.sweep_reload = true
+	case 2:
+		p.period = (p.period & 0x700) | uint16(val)
+	case 3:
+		p.period = (p.period & 0xff) | (uint16(val&7) << 8)
+		p.length.load(val)
+		p.seq = 0
+		p.env.start = true
+	}
+}
+
+// Return the sweep target period.
+func (p *nes_pulse_channel) target() int {
+	d := int(p.period >> p.sweep_shift)
+	if p.sweep_negate {
+		d = -d
+		if p.ones {
+			d--
+		}
+	}
+	return int(p.period) + d
+}
+
+func (p *nes_pulse_channel) muted() bool {
+	return p.period < 8 || p.target() > 0x7ff
+}
+
+// clocked on a half frame
+func (p *nes_pulse_channel) clock_sweep() {
+	if p.sweep_div == 0 && p.sweep_on && p.sweep_shift > 0 && !p.muted() {
+		p.period = uint16(p.target())
+	}
+	if p.sweep_div == 0 || p.sweep_reload {
+		p.sweep_div = p.sweep_period
+		p.sweep_reload = false
+	} else {
+		p.sweep_div--
+	}
+}
+
+// clocked every other cpu cycle
+func (p *nes_pulse_channel) clock() {
+	if p.timer == 0 {
+		p.timer = p.period
+		p.seq = (p.seq + 1) & 7
+	} else {
+		p.timer--
+	}
+}
+
+func (p *nes_pulse_channel) output() uint8 {
+	if p.length.count == 0 || p.muted() || nes_duty[p.duty][p.seq] == 0 {
+		return 0
+	}
+	return p.env.output()
+}
+
+//-----------------------------------------------------------------------------
+
+type nes_triangle_channel struct {
+	length  nes_length_counter
+	control bool  // halt the length counter and hold the linear counter
+	reload  uint8 // linear counter reload value
+	linear  uint8 // linear counter
+	flag    bool  // linear counter reload flag
+	seq     uint8
+	period  uint16
+	timer   uint16
+}
+
+func (t *nes_triangle_channel) write(reg int, val uint8) {
+	switch reg {
+	case 0:
+		t.control = val&0x80 != 0
+		t.length.halt = t.control
+		t.reload = val & 0x7f
+	case 2:
+		t.period = (t.period & 0x700) | uint16(val)
+	case 3:
+		t.period = (t.period & 0xff) | (uint16(val&7) << 8)
+		t.length.load(val)
+		t.flag = true
+	}
+}
+
+// clocked on a quarter frame
+func (t *nes_triangle_channel) clock_linear() {
+	if t.flag {
+		t.linear = t.reload
+	} else if t.linear > 0 {
+		t.linear--
+	}
+	if !t.control {
+		t.flag = false
+	}
+}
+
+// clocked every cpu cycle
+func (t *nes_triangle_channel) clock() {
+	if t.timer == 0 {
+		t.timer = t.period
+		// ultrasonic periods are silenced rather than popping
+		if t.linear > 0 && t.length.count > 0 && t.period >= 2 {
+			t.seq = (t.seq + 1) & 31
+		}
+	} else {
+		t.timer--
+	}
+}
+
+func (t *nes_triangle_channel) output() uint8 {
+	return nes_triangle_seq[t.seq]
+}
+
+//-----------------------------------------------------------------------------
+
+type nes_noise_channel struct {
+	env    nes_envelope
+	length nes_length_counter
+	short  bool   // short (93 step) mode
+	period uint16 // timer period
+	timer  uint16
+	lfsr   uint16
+}
+
+func (n *nes_noise_channel) write(reg int, val uint8) {
+	switch reg {
+	case 0:
+		n.env.write(val)
+		n.length.halt = val&0x20 != 0
+	case 2:
+		n.short = val&0x80 != 0
+		n.period = nes_noise_period[val&15]
+	case 3:
+		n.length.load(val)
+		n.env.start = true
+	}
+}
+
+// clocked every cpu cycle
+func (n *nes_noise_channel) clock() {
+	if n.timer == 0 {
+		n.timer = n.period - 1
+		tap := uint16(1)
+		if n.short {
+			tap = 6
+		}
+		fb := (n.lfsr ^ (n.lfsr >> tap)) & 1
+		n.lfsr = (n.lfsr >> 1) | (fb << 14)
+	} else {
+		n.timer--
+	}
+}
+
+func (n *nes_noise_channel) output() uint8 {
+	if n.length.count == 0 || n.lfsr&1 != 0 {
+		return 0
+	}
+	return n.env.output()
+}
+
+//-----------------------------------------------------------------------------
+
+type nes_dmc_channel struct {
+	loop    bool
+	period  uint16 // timer period
+	timer   uint16
+	level   uint8  // output level (0..127)
+	addr    uint16 // sample start address
+	n       uint16 // sample length in bytes
+	cur     uint16 // current address
+	left    uint16 // bytes remaining
+	buf     uint8  // sample buffer
+	full    bool   // the sample buffer is full
+	shift   uint8  // output shift register
+	bits    uint8  // bits remaining in the shift register
+	silence bool
+}
+
+func (d *nes_dmc_channel) write(reg int, val uint8) {
+	switch reg {
+	case 0:
+		d.loop = val&0x40 != 0
+		d.period = nes_dmc_period[val&15]
+	case 1:
+		d.level = val & 0x7f
+	case 2:
+		d.addr = 0xc000 | (uint16(val) << 6)
+	case 3:
+		d.n = (uint16(val) << 4) | 1
+	}
+}
+
+func (d *nes_dmc_channel) restart() {
+	d.cur = d.addr
+	d.left = d.n
+}
+
+// clocked every cpu cycle
+func (d *nes_dmc_channel) clock(mem []byte) {
+	// memory reader
+	if !d.full && d.left > 0 {
+		if d.cur >= 0xc000 {
+			d.buf = mem[d.cur-0xc000]
+		} else {
+			// only $C000..$FFFF is mapped
+			d.buf = 0
+		}
+		d.full = true
+		if d.cur == 0xffff {
+			d.cur = 0x8000
+		} else {
+			d.cur++
+		}
+		d.left--
+		if d.left == 0 && d.loop {
+			d.restart()
+		}
+	}
+	if d.timer > 0 {
+		d.timer--
+		return
+	}
+	d.timer = d.period - 1
+	// output unit
+	if !d.silence {
+		if d.shift&1 != 0 {
+			if d.level <= 125 {
+				d.level += 2
+			}
+		} else if d.level >= 2 {
+			d.level -= 2
+		}
+		d.shift >>= 1
+	}
+	if d.bits > 0 {
+		d.bits--
+	}
+	if d.bits == 0 {
+		d.bits = 8
+		d.silence = !d.full
+		if d.full {
+			d.shift = d.buf
+			d.full = false
+		}
+	}
+}
+
+//-----------------------------------------------------------------------------
+
+type APU struct {
+	pulse    [2]nes_pulse_channel
+	triangle nes_triangle_channel
+	noise    nes_noise_channel
+	dmc      nes_dmc_channel
+	mem      []byte  // memory at $C000..$FFFF for DMC samples
+	status   uint8   // last $4015 write
+	mode5    bool    // 5 step frame counter mode
+	frame    int     // frame counter cpu cycle
+	cycle    int     // cpu cycle count
+	kcycles  float64 // cpu cycles per sample
+	ncycles  float64 // cpu cycles owed to the next sample
+	dc       dcblock
+}
+
+// Return an NES APU.
+func NewAPU(rate int) *APU {
+	a := &APU{
+		mem:     make([]byte, 0x4000),
+		kcycles: float64(nes_clock) / float64(rate),
+	}
+	a.pulse[0].ones = true
+	a.noise.lfsr = 1
+	a.noise.period = nes_noise_period[0]
+	a.dmc.period = nes_dmc_period[0]
+	a.dmc.bits = 8
+	a.dmc.silence = true
+	return a
+}
+
+// Load DMC sample data into memory at $C000 and point the DMC at it.
+func (a *APU) SetSample(data []byte) error {
+	if len(data) == 0 || len(data) > 0xff1 {
+		return errors.New("bad dmc sample length")
+	}
+	// dmc samples are 16n+1 bytes long, pad with bytes that hold the level
+	n := copy(a.mem, data)
+	for i := n; i < len(a.mem); i++ {
+		a.mem[i] = 0x55
+	}
+	a.Write(0x4012, 0)
+	a.Write(0x4013, uint8((len(data)+14)>>4))
+	return nil
+}
+
+// Write an APU register ($4000..$4017).
+func (a *APU) Write(addr uint16, val uint8) {
+	switch {
+	case addr >= 0x4000 && addr <= 0x4007:
+		a.pulse[(addr>>2)&1].write(int(addr&3), val)
+	case addr >= 0x4008 && addr <= 0x400b:
+		a.triangle.write(int(addr&3), val)
+	case addr >= 0x400c && addr <= 0x400f:
+		a.noise.write(int(addr&3), val)
+	case addr >= 0x4010 && addr <= 0x4013:
+		a.dmc.write(int(addr&3), val)
+	case addr == 0x4015:
+		a.status = val
+		a.pulse[0].length.enable(val&1 != 0)
+		a.pulse[1].length.enable(val&2 != 0)
+		a.triangle.length.enable(val&4 != 0)
+		a.noise.length.enable(val&8 != 0)
+		if val&16 == 0 {
+			a.dmc.left = 0
+		} else if a.dmc.left == 0 {
+			a.dmc.restart()
+		}
+	case addr == 0x4017:
+		a.mode5 = val&0x80 != 0
+		a.frame = 0
+		if a.mode5 {
+			a.quarter_frame()
+			a.half_frame()
+		}
+	}
+}
+
+func (a *APU) quarter_frame() {
+	a.pulse[0].env.clock()
+	a.pulse[1].env.clock()
+	a.triangle.clock_linear()
+	a.noise.env.clock()
+}
+
+func (a *APU) half_frame() {
+	for i := range a.pulse {
+		a.pulse[i].length.clock()
+		a.pulse[i].clock_sweep()
+	}
+	a.triangle.length.clock()
+	a.noise.length.clock()
+}
+
+// Run the frame counter for a cpu cycle.
+func (a *APU) clock_frame() {
+	a.frame++
+	switch a.frame {
+	case 7457, 22371:
+		a.quarter_frame()
+	case 14913:
+		a.quarter_frame()
+		a.half_frame()
+	case 29829:
+		if !a.mode5 {
+			a.quarter_frame()
+			a.half_frame()
+			a.frame = 0
+		}
+	case 37281:
+		a.quarter_frame()
+		a.half_frame()
+		a.frame = 0
+	}
+}
+
+// Return the non-linear mixer output for the current channel outputs.
+func (a *APU) mix() float32 {
+	var y float32
+	p := float32(a.pulse[0].output()) + float32(a.pulse[1].output())
+	if p > 0 {
+		y = 95.88 / ((8128.0 / p) + 100.0)
+	}
+	t := float32(a.triangle.output()) / 8227.0
+	t += float32(a.noise.output()) / 12241.0
+	t += float32(a.dmc.level) / 22638.0
+	if t > 0 {
+		y += 159.79 / ((1.0 / t) + 100.0)
+	}
+	return y
+}
+
+func (a *APU) Sample() float32 {
+	a.ncycles += a.kcycles
+	n := int(a.ncycles)
+	a.ncycles -= float64(n)
+	var y float32
+	for i := 0; i < n; i++ {
+		a.clock_frame()
+		if a.cycle&1 == 0 {
+			a.pulse[0].clock()
+			a.pulse[1].clock()
+		}
+		a.triangle.clock()
+		a.noise.clock()
+		a.dmc.clock(a.mem)
+		a.cycle++
+		y += a.mix()
+	}
+	if n > 0 {
+		y /= float32(n)
+	}
+	return a.dc.tick(y)
+}
+
+//-----------------------------------------------------------------------------
+// MIDI voices
+
+type NESChannel int
+
+const (
+	nes_pulse1 NESChannel = iota
+	nes_pulse2
+	nes_triangle
+	nes_noise
+	nes_dmc
+)
+
+var nes_channel_txt = map[NESChannel]string{
+	nes_pulse1:   "pulse1",
+	nes_pulse2:   "pulse2",
+	nes_triangle: "triangle",
+	nes_noise:    "noise",
+	nes_dmc:      "dmc",
+}
+
+func (x NESChannel) String() string {
+	return nes_channel_txt[x]
+}
+
+type NES_Voice struct {
+	apu   *APU
+	ch    NESChannel
+	duty  uint8 // pulse duty cycle
+	short bool  // short noise mode
+	note  uint  // note being played
+}
+
+// Return a voice that drives an APU channel.
+func NewNES_Voice(apu *APU, ch NESChannel) (*NES_Voice, error) {
+	if ch < nes_pulse1 || ch > nes_dmc {
+		return nil, errors.New("bad nes channel")
+	}
+	return &NES_Voice{
+		apu:  apu,
+		ch:   ch,
+		duty: 2,
+	}, nil
+}
+
+// Set or clear the channel enable bit in $4015.
+func (v *NES_Voice) enable(on bool) {
+	bit := uint8(1) << uint(v.ch)
+	if on {
+		v.apu.Write(0x4015, v.apu.status|bit)
+	} else {
+		v.apu.Write(0x4015, v.apu.status&^bit)
+	}
+}
+
+func (v *NES_Voice) NoteOn(note uint, velocity uint) {
+	vol := uint8(cc_to_float(velocity) * 15.0)
+	v.note = note
+	switch v.ch {
+	case nes_pulse1, nes_pulse2:
+		t := int(float32(nes_clock)/(16.0*midi_to_frequency(note))+0.5) - 1
+		if t < 8 || t > 0x7ff {
+			// out of range
+			return
+		}
+		base := 0x4000 + uint16(v.ch)*4
+		v.enable(true)
+		// constant volume, sweep negate so the channel isn't muted
+		v.apu.Write(base, (v.duty<<6)|0x30|vol)
+		v.apu.Write(base+1, 0x08)
+		v.apu.Write(base+2, uint8(t))
+		v.apu.Write(base+3, 0x08|uint8(t>>8))
+	case nes_triangle:
+		t := int(float32(nes_clock)/(32.0*midi_to_frequency(note))+0.5) - 1
+		if t < 2 || t > 0x7ff {
+			return
+		}
+		v.enable(true)
+		v.apu.Write(0x4008, 0xff)
+		v.apu.Write(0x400a, uint8(t))
+		v.apu.Write(0x400b, 0x08|uint8(t>>8))
+	case nes_noise:
+		// higher notes have shorter periods
+		p := uint8(15 - (note&127)/8)
+		if v.short {
+			p |= 0x80
+		}
+		v.enable(true)
+		v.apu.Write(0x400c, 0x30|vol)
+		v.apu.Write(0x400e, p)
+		v.apu.Write(0x400f, 0x08)
+	case nes_dmc:
+		// the note selects the playback rate
+		v.apu.Write(0x4010, uint8(note&15))
+		v.enable(false)
+		v.enable(true)
+	}
+}
+
+func (v *NES_Voice) NoteOff() {
+	switch v.ch {
+	case nes_pulse1, nes_pulse2:
+		v.apu.Write(0x4000+uint16(v.ch)*4, (v.duty<<6)|0x30)
+	case nes_noise:
+		v.apu.Write(0x400c, 0x30)
+	case nes_triangle:
+		v.enable(false)
+	}
+}
+
+// The modulation wheel sets the pulse duty cycle or the noise mode.
+func (v *NES_Voice) ControlChange(cc uint, val uint) {
+	if cc != cc_modulation {
+		return
+	}
+	switch v.ch {
+	case nes_pulse1, nes_pulse2:
+		v.duty = uint8(val>>5) & 3
+		base := 0x4000 + uint16(v.ch)*4
+		p := &v.apu.pulse[v.ch]
+		v.apu.Write(base, (v.duty<<6)|0x30|p.env.volume)
+	case nes_noise:
+		v.short = val >= 64
+		v.apu.noise.short = v.short
+	}
+}
+
+//-----------------------------------------------------------------------------
@@ -0,0 +1,145 @@
+//-----------------------------------------------------------------------------
+/*
+
+Noise Generators
+
+White, pink and brown noise, and a sample and hold random stepper.
+All of them use a seedable pseudo random number generator so the output
+is deterministic for a given seed.
+
+*/
+//-----------------------------------------------------------------------------
+
+package main
+
+import "math/bits"
+
+//-----------------------------------------------------------------------------
+// Pseudo Random Number Generator (xorshift32)
+
+type PRNG struct {
+	state uint32
+}
+
+func NewPRNG(seed uint32) *PRNG {
+	r := &PRNG{}
+	r.Seed(seed)
+	return r
+}
+
+func (r *PRNG) Seed(seed uint32) {
+	if seed == 0 {
+		// xorshift gets stuck on 0
+		seed = 0x2545f491
+	}
+	r.state = seed
+}
+
+// Return a random uint32.
+func (r *PRNG) Uint32() uint32 {
+	x := r.state
+	x ^= x << 13
+	x ^= x >> 17
+	x ^= x << 5
+	r.state = x
+	return x
+}
+
+// Return a random float32 in [0,1).
+func (r *PRNG) Float() float32 {
+	return float32(r.Uint32()>>8) / (1 << 24)
+}
+
+// Return a random float32 in [-1,1).
+func (r *PRNG) Bipolar() float32 {
+	return (2.0 * r.Float()) - 1.0
+}
+
+//-----------------------------------------------------------------------------
+
+type NoiseType int
+
+const (
+	noise_white NoiseType = iota
+	noise_pink
+	noise_brown
+	noise_sample_hold
+)
+
+const pink_rows = 12 // number of Voss-McCartney rows
+
+type Noise struct {
+	kind  NoiseType          // noise type
+	rng   PRNG               // random number generator
+	rows  [pink_rows]float32 // pink noise rows
+	count uint32             // pink noise row counter
+	sum   float32            // pink noise row sum, brown noise level
+	x     float32            // sample and hold phase (0..1)
+	step  float32            // sample and hold phase step
+	val   float32            // sample and hold value
+}
+
+// Set the sample and hold clock frequency.
+func (n *Noise) SetStep(f float32, rate int) {
+	n.step = f / float32(rate)
+}
+
+func (n *Noise) Sample() float32 {
+	switch n.kind {
+	case noise_white:
+		return n.rng.Bipolar()
+	case noise_pink:
+		// Voss-McCartney: update one row per sample, row k every 2^(k+1) samples
+		n.count++
+		k := bits.TrailingZeros32(n.count)
+		if k < pink_rows {
+			x := n.rng.Bipolar()
+			n.sum += x - n.rows[k]
+			n.rows[k] = x
+		}
+		return (n.sum + n.rng.Bipolar()) / (pink_rows + 1)
+	case noise_brown:
+		// leaky integration of white noise
+		n.sum = (n.sum + (0.02 * n.rng.Bipolar())) / 1.02
+		return n.sum * 3.5
+	case noise_sample_hold:
+		n.x += n.step
+		if n.x >= 1.0 {
+			n.x -= 1.0
+			n.val = n.rng.Bipolar()
+		}
+		return n.val
+	}
+	panic("bad noise type")
+}
+
+//-----------------------------------------------------------------------------
+
+func NewNoise_White(seed uint32) *Noise {
+	n := &Noise{kind: noise_white}
+	n.rng.Seed(seed)
+	return n
+}
+
+func NewNoise_Pink(seed uint32) *Noise {
+	n := &Noise{kind: noise_pink}
+	n.rng.Seed(seed)
+	return n
+}
+
+func NewNoise_Brown(seed uint32) *Noise {
+	n := &Noise{kind: noise_brown}
+	n.rng.Seed(seed)
+	return n
+}
+
+// Return a sample and hold random stepper clocked at frequency f.
+func NewNoise_SampleHold(f float32, rate int, seed uint32) *Noise {
+	n := &Noise{kind: noise_sample_hold}
+	n.rng.Seed(seed)
+	n.val = n.rng.Bipolar()
+	n.SetStep(f, rate)
+	return n
+}
+
+//-----------------------------------------------------------------------------
@@ -0,0 +1,168 @@
+//-----------------------------------------------------------------------------
+/*
+
+Phase Distortion Oscillator
+
+Casio CZ style phase distortion. The phase (0..1) is warped before it is
+used to read the cosine table. With an amount of 0 the output is a cosine.
+Increasing the amount sharpens the waveform towards the selected shape,
+giving filter-like sweeps without a filter.
+
+The resonant shapes read the cosine at a higher frequency (set by the
+amount) and window it with a sawtooth, triangle or trapezoid.
+
+*/
+//-----------------------------------------------------------------------------
+
+package main
+
+//-----------------------------------------------------------------------------
+
+type PDShape int
+
+const (
+	pd_sawtooth PDShape = iota
+	pd_square
+	pd_pulse
+	pd_resonant_saw
+	pd_resonant_triangle
+	pd_resonant_trapezoid
+)
+
+var pd_txt = map[PDShape]string{
+	pd_sawtooth:           "sawtooth",
+	pd_square:             "square",
+	pd_pulse:              "pulse",
+	pd_resonant_saw:       "resonant saw",
+	pd_resonant_triangle:  "resonant triangle",
+	pd_resonant_trapezoid: "resonant trapezoid",
+}
+
+func (x PDShape) String() string {
+	return pd_txt[x]
+}
+
+//-----------------------------------------------------------------------------
+
+type PD struct {
+	lut    LUT     // cosine table lookup
+	shape  PDShape // distortion shape
+	amount float32 // distortion amount (0..1)
+	x      float32 // phase (0..1)
+	step   float32 // phase step per sample
+}
+
+// Return a phase distortion oscillator.
+func NewPD(shape PDShape, amount, f float32, rate int) *PD {
+	t := &PD{shape: shape}
+	t.lut.SetTable(cos_table)
+	t.SetAmount(amount)
+	t.SetStep(f, rate)
+	return t
+}
+
+func (t *PD) SetStep(f float32, rate int) {
+	t.step = f / float32(rate)
+}
+
+// Set the distortion amount (0..1). This may be modulated per sample.
+func (t *PD) SetAmount(amount float32) {
+	if amount < 0 {
+		amount = 0
+	} else if amount > 1.0 {
+		amount = 1.0
+	}
+	t.amount = amount
+}
+
+// Set the table interpolation mode.
+func (t *PD) SetInterpolation(mode Interpolation) {
+	t.lut.SetInterpolation(mode)
+}
+
+//-----------------------------------------------------------------------------
+
+// Return the phase warped by a two segment transfer function with a knee at (d, 0.5).
+func pd_knee(x, d float32) float32 {
+	if x < d {
+		return 0.5 * x / d
+	}
+	return 0.5 + (0.5 * (x - d) / (1.0 - d))
+}
+
+// Return the cosine of a phase (0..1 is one cycle).
+func (t *PD) cos(x float32) float32 {
+	x -= float32(int(x))
+	x *= t.lut.xrange
+	x0 := int(x)
+	if x0 >= len(t.lut.table) {
+		// float32 rounding
+		x0 = 0
+	}
+	return table_interpolate(t.lut.table, x0, x-float32(x0), t.lut.interp)
+}
+
+func (t *PD) Sample() float32 {
+	x := t.x
+	// knee position: 0.5 (no distortion) down to nearly 0
+	d := 0.5 - (0.49 * t.amount)
+	var y float32
+	switch t.shape {
+	case pd_sawtooth:
+		y = t.cos(pd_knee(x, d))
+	case pd_square:
+		// hold at the top and bottom with fast transitions
+		var p float32
+		switch {
+		case x < 0.5-d:
+			p = 0
+		case x < 0.5:
+			p = 0.5 * (x - (0.5 - d)) / d
+		case x < 1.0-d:
+			p = 0.5
+		default:
+			p = 0.5 + (0.5 * (x - (1.0 - d)) / d)
+		}
+		y = t.cos(p)
+	case pd_pulse:
+		// hold at the top, then a fast cosine cycle
+		w := 1.0 - (0.9 * t.amount)
+		if x < 1.0-w {
+			y = 1.0
+		} else {
+			y = t.cos((x - (1.0 - w)) / w)
+		}
+	case pd_resonant_saw, pd_resonant_triangle, pd_resonant_trapezoid:
+		// resonance frequency ratio: 1..16
+		r := 1.0 + (15.0 * t.amount)
+		var w float32
+		switch t.shape {
+		case pd_resonant_saw:
+			w = 1.0 - x
+		case pd_resonant_triangle:
+			if x < 0.5 {
+				w = 2.0 * x
+			} else {
+				w = 2.0 * (1.0 - x)
+			}
+		default:
+			if x < 0.5 {
+				w = 1.0
+			} else {
+				w = 2.0 * (1.0 - x)
+			}
+		}
+		// windowed cosine, starting and ending at the bottom of the wave
+		y = (w * (1.0 - t.cos(r*x))) - 1.0
+	default:
+		panic("bad phase distortion shape")
+	}
+	// step the x position
+	t.x += t.step
+	if t.x >= 1.0 {
+		t.x -= 1.0
+	}
+	return y
+}
+
+//-----------------------------------------------------------------------------
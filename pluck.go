@@ -0,0 +1,155 @@
+//-----------------------------------------------------------------------------
+/*
+
+Karplus-Strong Plucked String
+
+A delay line (the string) with a damping filter in the feedback loop.
+A note is started by injecting a burst of noise into the loop. The pick
+position is simulated with a comb filter on the noise burst.
+
+*/
+//-----------------------------------------------------------------------------
+
+package main
+
+import (
+	"errors"
+	"math"
+)
+
+//-----------------------------------------------------------------------------
+
+const pluck_min_frequency = 20.0 // lowest note frequency
+
+type Pluck struct {
+	delay  *Delay    // string delay line
+	rng    PRNG      // excitation noise
+	burst  []float32 // excitation buffer
+	n      int       // excitation length
+	i      int       // excitation index
+	decay  float32   // decay time (seconds to -60 dB)
+	bright float32   // brightness (0..1)
+	pick   float32   // pick position (0..1)
+	s      float32   // damping filter coefficient
+	g      float32   // loop gain
+	prev   float32   // damping filter state
+	f      float32   // note frequency
+	rate   int       // sample rate
+}
+
+// Return a plucked string voice.
+func NewPluck(
+	decay float32, // decay time in seconds
+	bright float32, // brightness (0..1)
+	pick float32, // pick position (0..1)
+	rate int, // sample rate
+) (*Pluck, error) {
+	if decay <= 0 {
+		return nil, errors.New("bad decay time")
+	}
+	if bright < 0 || bright > 1.0 {
+		return nil, errors.New("bad brightness")
+	}
+	if pick < 0 || pick > 1.0 {
+		return nil, errors.New("bad pick position")
+	}
+	n := int(float32(rate)/pluck_min_frequency) + 1
+	p := &Pluck{
+		delay:  NewDelay(n),
+		burst:  make([]float32, n),
+		decay:  decay,
+		bright: bright,
+		pick:   pick,
+		rate:   rate,
+	}
+	p.rng.Seed(1)
+	return p, nil
+}
+
+//-----------------------------------------------------------------------------
+
+// Set the decay time in seconds.
+func (p *Pluck) SetDecay(decay float32) {
+	p.decay = decay
+	p.update()
+}
+
+// Set the brightness (0..1).
+func (p *Pluck) SetBrightness(bright float32) {
+	p.bright = bright
+	p.update()
+}
+
+// Set the pick position (0..1) used for the next note.
+func (p *Pluck) SetPickPosition(pick float32) {
+	p.pick = pick
+}
+
+// Update the loop parameters.
+func (p *Pluck) update() {
+	if p.f <= 0 {
+		return
+	}
+	// brightness 1 is no damping, 0 is a 2 point average
+	p.s = 0.5 * (1.0 - p.bright)
+	// the loop delay is the delay line, the damping filter and the feedback sample
+	p.delay.SetDelay((float32(p.rate) / p.f) - p.s - 1.0)
+	// loop gain to decay by 60 dB in the decay time
+	p.g = float32(math.Pow(0.001, 1.0/float64(p.decay*p.f)))
+}
+
+//-----------------------------------------------------------------------------
+
+// Pluck the string.
+func (p *Pluck) NoteOn(note uint, velocity uint) {
+	f := midi_to_frequency(note)
+	if f < pluck_min_frequency {
+		f = pluck_min_frequency
+	}
+	p.f = f
+	p.update()
+	// one period of noise, lowpass filtered for softer tones
+	p.n = int(float32(p.rate) / f)
+	amp := float32(velocity) / 127.0
+	k := 0.2 + (0.8 * p.bright)
+	var y float32
+	for i := 0; i < p.n; i++ {
+		y += k * (p.rng.Bipolar() - y)
+		p.burst[i] = amp * y
+	}
+	// pick position comb filter
+	d := int(p.pick * float32(p.n))
+	if d > 0 {
+		for i := p.n - 1; i >= d; i-- {
+			p.burst[i] = 0.5 * (p.burst[i] - p.burst[i-d])
+		}
+		for i := 0; i < d; i++ {
+			p.burst[i] *= 0.5
+		}
+	}
+	p.i = 0
+}
+
+// Damp the string.
+func (p *Pluck) NoteOff() {
+	if p.f > 0 {
+		p.g = float32(math.Pow(0.001, 1.0/float64(0.1*p.f)))
+	}
+}
+
+func (p *Pluck) Sample() float32 {
+	var x float32
+	if p.i < p.n {
+		// excitation
+		x = p.burst[p.i]
+		p.i++
+	}
+	y := p.delay.LastOut()
+	// damping filter
+	fb := p.g * (((1.0 - p.s) * y) + (p.s * p.prev))
+	p.prev = y
+	p.delay.Tick(x + fb)
+	return y
+}
+
+//-----------------------------------------------------------------------------
@@ -0,0 +1,430 @@
+//-----------------------------------------------------------------------------
+/*
+
+Sampler Voice
+
+Play back a recorded sample repitched relative to its root note, with an
+ADSR amplitude envelope. Samples can be played once, or looped forwards,
+back and forth (ping-pong), or forwards only while the note is held
+(sustain loop). Forward loops can be crossfaded to hide the loop point.
+
+A sampler instrument maps key and velocity ranges onto sample regions and
+plays them through a fixed pool of voices. When the pool is full the oldest
+voice is stolen.
+
+*/
+//-----------------------------------------------------------------------------
+
+package main
+
+import (
+	"errors"
+	"fmt"
+	"math"
+)
+
+//-----------------------------------------------------------------------------
+
+type LoopMode int
+
+const (
+	loop_none     LoopMode = iota // play once
+	loop_forward                  // loop forwards
+	loop_pingpong                 // loop forwards and backwards
+	loop_sustain                  // loop forwards while the note is held
+)
+
+var loop_txt = map[LoopMode]string{
+	loop_none:     "none",
+	loop_forward:  "forward",
+	loop_pingpong: "pingpong",
+	loop_sustain:  "sustain",
+}
+
+func (x LoopMode) String() string {
+	return loop_txt[x]
+}
+
+//-----------------------------------------------------------------------------
+
+type SampleData struct {
+	data       []float32 // mono samples
+	rate       int       // sample rate
+	root       uint      // root note
+	tune       float32   // fine tuning in cents
+	start, end int       // play region [start, end)
+	loop_start int       // first sample of the loop
+	loop_end   int       // first sample after the loop
+	mode       LoopMode  // loop mode
+	xfade      int       // loop crossfade length in samples
+}
+
+// Return sample data with a play region of all samples.
+func NewSampleData(data []float32, rate int, root uint) *SampleData {
+	return &SampleData{
+		data:     data,
+		rate:     rate,
+		root:     root,
+		end:      len(data),
+		loop_end: len(data),
+	}
+}
+
+// Set the loop mode and region [start, end).
+func (s *SampleData) SetLoop(mode LoopMode, start, end int) error {
+	if mode != loop_none && (start < s.start || end > s.end || end-start < 2) {
+		return errors.New("bad loop points")
+	}
+	s.mode = mode
+	s.loop_start = start
+	s.loop_end = end
+	return nil
+}
+
+// Set the forward loop crossfade length in samples.
+func (s *SampleData) SetCrossfade(n int) {
+	// the fade in comes from before the loop start
+	if n > s.loop_start-s.start {
+		n = s.loop_start - s.start
+	}
+	if n > s.loop_end-s.loop_start {
+		n = s.loop_end - s.loop_start
+	}
+	if n < 0 {
+		n = 0
+	}
+	s.xfade = n
+}
+
+// Return the interpolated sample value at a fractional position.
+func (s *SampleData) read(pos float64) float32 {
+	i := int(pos)
+	x := float32(pos - float64(i))
+	get := func(j int) float32 {
+		if j < s.start || j >= s.end {
+			return 0
+		}
+		return s.data[j]
+	}
+	return hermite(get(i-1), get(i), get(i+1), get(i+2), x)
+}
+
+// Load a sample from a WAV file.
+// The root note and loop points from the file are used if present.
+func load_sample(path string, root uint) (*SampleData, error) {
+	w, err := read_wav(path)
+	if err != nil {
+		return nil, err
+	}
+	if w.Length() == 0 {
+		return nil, fmt.Errorf("%s: no samples", path)
+	}
+	// mix down to mono
+	data := w.data[0]
+	if w.Channels() > 1 {
+		data = make([]float32, w.Length())
+		k := 1.0 / float32(w.Channels())
+		for _, ch := range w.data {
+			for i, x := range ch {
+				data[i] += x * k
+			}
+		}
+	}
+	if w.root >= 0 && w.root <= 127 {
+		root = uint(w.root)
+	}
+	s := NewSampleData(data, w.rate, root)
+	if w.loop {
+		if err := s.SetLoop(loop_forward, w.loop_start, w.loop_end+1); err != nil {
+			return nil, fmt.Errorf("%s: %s", path, err)
+		}
+	}
+	return s, nil
+}
+
+//-----------------------------------------------------------------------------
+
+type SamplerVoice struct {
+	s       *SampleData // sample data
+	env     ADSR        // amplitude envelope
+	pos     float64     // sample position
+	inc     float64     // sample increment per output sample
+	dir     float64     // +1 forwards, -1 backwards
+	looping bool        // the voice is looping
+	gain    float32     // velocity gain
+	note    uint        // note being played
+	active  bool        // the voice is sounding
+	rate    int         // output sample rate
+}
+
+// Return a sampler voice.
+func NewSamplerVoice(s *SampleData, a, d, sus, r float32, rate int) (*SamplerVoice, error) {
+	v := &SamplerVoice{
+		s:    s,
+		rate: rate,
+	}
+	if err := v.SetEnvelope(a, d, sus, r); err != nil {
+		return nil, err
+	}
+	return v, nil
+}
+
+// Set the sample data.
+func (v *SamplerVoice) SetSample(s *SampleData) {
+	v.s = s
+}
+
+// Set the amplitude envelope.
+func (v *SamplerVoice) SetEnvelope(a, d, s, r float32) error {
+	e, err := NewADSR_Envelope(a, d, s, r, v.rate)
+	if err != nil {
+		return err
+	}
+	v.env = *e
+	return nil
+}
+
+// Start playing a note.
+func (v *SamplerVoice) NoteOn(note uint, velocity uint) {
+	s := v.s
+	f := float64(midi_to_frequency(note)) / float64(midi_to_frequency(s.root))
+	f *= math.Pow(2.0, float64(s.tune)/1200.0)
+	v.inc = f * float64(s.rate) / float64(v.rate)
+	v.pos = float64(s.start)
+	v.dir = 1.0
+	v.looping = s.mode != loop_none
+	v.gain = cc_to_float(velocity)
+	v.note = note
+	v.active = true
+	v.env.Attack()
+}
+
+// Release the note.
+func (v *SamplerVoice) NoteOff() {
+	if v.s.mode == loop_sustain {
+		// play on to the end of the sample
+		v.looping = false
+	}
+	v.env.Release()
+}
+
+// Stop the voice.
+func (v *SamplerVoice) Stop() {
+	v.env.Idle()
+	v.active = false
+}
+
+// Return true if the voice is sounding.
+func (v *SamplerVoice) Active() bool {
+	return v.active
+}
+
+func (v *SamplerVoice) Sample() float32 {
+	if !v.active {
+		return 0
+	}
+	s := v.s
+	y := s.read(v.pos)
+	if v.looping && s.mode != loop_pingpong && s.xfade > 0 {
+		// crossfade the end of the loop with the samples before the loop start
+		x0 := float64(s.loop_end - s.xfade)
+		if v.pos >= x0 {
+			k := float32((v.pos - x0) / float64(s.xfade))
+			y = ((1.0 - k) * y) + (k * s.read(v.pos-float64(s.loop_end-s.loop_start)))
+		}
+	}
+	y *= v.gain * v.env.Sample()
+	// step the position
+	v.pos += v.inc * v.dir
+	if v.looping {
+		lstart := float64(s.loop_start)
+		lend := float64(s.loop_end)
+		n := lend - lstart
+		if s.mode == loop_pingpong {
+			// reflect at the loop ends
+			for v.pos >= lend || v.pos < lstart {
+				if v.pos >= lend {
+					v.pos = (2.0 * lend) - v.pos - 1.0
+					v.dir = -1.0
+				} else {
+					v.pos = (2.0 * lstart) - v.pos
+					v.dir = 1.0
+				}
+			}
+		} else if v.pos >= lend {
+			v.pos -= n * math.Floor((v.pos-lstart)/n)
+		}
+	} else if v.pos >= float64(s.end) || v.pos < float64(s.start) {
+		v.Stop()
+	}
+	if v.env.state == idle {
+		v.active = false
+	}
+	return y
+}
+
+//-----------------------------------------------------------------------------
+
+type SamplerRegion struct {
+	s            *SampleData // sample data
+	lokey, hikey uint        // key range
+	lovel, hivel uint        // velocity range
+	gain         float32     // linear gain
+	pan          float32     // stereo position (-1..1)
+	env          *ADSR       // amplitude envelope
+	group        int         // group number, 0 for none
+	off_by       int         // group whose notes stop this region, 0 for none
+	oneshot      bool        // ignore note off
+}
+
+// Return a sampler region covering all keys and velocities.
+func NewSamplerRegion(s *SampleData, a, d, sus, r float32, rate int) (*SamplerRegion, error) {
+	env, err := NewADSR_Envelope(a, d, sus, r, rate)
+	if err != nil {
+		return nil, err
+	}
+	return &SamplerRegion{
+		s:     s,
+		hikey: 127,
+		hivel: 127,
+		gain:  1.0,
+		env:   env,
+	}, nil
+}
+
+// Return true if the region plays for this note and velocity.
+func (r *SamplerRegion) match(note, velocity uint) bool {
+	return note >= r.lokey && note <= r.hikey && velocity >= r.lovel && velocity <= r.hivel
+}
+
+//-----------------------------------------------------------------------------
+
+type sampler_slot struct {
+	v      SamplerVoice   // the voice
+	r      *SamplerRegion // region being played
+	gl, gr float32        // left/right gain
+	age    uint64         // note on count when started
+}
+
+type SamplerInstrument struct {
+	regions []*SamplerRegion // sample regions
+	slots   []sampler_slot   // voice pool
+	age     uint64           // note on count
+	rate    int              // sample rate
+}
+
+// Return a sampler instrument with n voices.
+func NewSamplerInstrument(regions []*SamplerRegion, n int, rate int) (*SamplerInstrument, error) {
+	if n <= 0 {
+		return nil, errors.New("bad polyphony")
+	}
+	return &SamplerInstrument{
+		regions: regions,
+		slots:   make([]sampler_slot, n),
+		rate:    rate,
+	}, nil
+}
+
+// Return a free voice slot, stealing the oldest voice if needed.
+func (si *SamplerInstrument) alloc() *sampler_slot {
+	var old *sampler_slot
+	for i := range si.slots {
+		sl := &si.slots[i]
+		if !sl.v.Active() {
+			return sl
+		}
+		if old == nil || sl.age < old.age {
+			old = sl
+		}
+	}
+	return old
+}
+
+// Start playing a note on all matching regions.
+func (si *SamplerInstrument) NoteOn(note uint, velocity uint) {
+	if velocity == 0 {
+		si.NoteOff(note)
+		return
+	}
+	// stop the voices turned off by these groups before starting any new voices,
+	// so layered regions in a group don't stop each other
+	for _, r := range si.regions {
+		if r.group == 0 || !r.match(note, velocity) {
+			continue
+		}
+		for i := range si.slots {
+			sl := &si.slots[i]
+			if sl.v.Active() && sl.r.off_by == r.group {
+				sl.v.Stop()
+			}
+		}
+	}
+	for _, r := range si.regions {
+		if !r.match(note, velocity) {
+			continue
+		}
+		sl := si.alloc()
+		si.age++
+		sl.age = si.age
+		sl.r = r
+		sl.v.s = r.s
+		sl.v.env = *r.env
+		sl.v.rate = si.rate
+		// equal power panning
+		a := float64(r.pan+1.0) * math.Pi / 4.0
+		sl.gl = r.gain * float32(math.Cos(a))
+		sl.gr = r.gain * float32(math.Sin(a))
+		sl.v.NoteOn(note, velocity)
+	}
+}
+
+// Release all voices playing a note.
+func (si *SamplerInstrument) NoteOff(note uint) {
+	for i := range si.slots {
+		sl := &si.slots[i]
+		if sl.v.Active() && sl.v.note == note && !sl.r.oneshot {
+			sl.v.NoteOff()
+		}
+	}
+}
+
+// Stop all voices.
+func (si *SamplerInstrument) AllNotesOff() {
+	for i := range si.slots {
+		si.slots[i].v.Stop()
+	}
+}
+
+// Return the number of sounding voices.
+func (si *SamplerInstrument) Active() int {
+	n := 0
+	for i := range si.slots {
+		if si.slots[i].v.Active() {
+			n++
+		}
+	}
+	return n
+}
+
+// Return a stereo sample.
+func (si *SamplerInstrument) SampleStereo() (float32, float32) {
+	var l, r float32
+	for i := range si.slots {
+		sl := &si.slots[i]
+		if !sl.v.Active() {
+			continue
+		}
+		y := sl.v.Sample()
+		l += y * sl.gl
+		r += y * sl.gr
+	}
+	return l, r
+}
+
+// Return a mono sample.
+func (si *SamplerInstrument) Sample() float32 {
+	l, r := si.SampleStereo()
+	return (l + r) * math.Sqrt2 / 2.0
+}
+
+//-----------------------------------------------------------------------------
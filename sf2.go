@@ -0,0 +1,566 @@
+//-----------------------------------------------------------------------------
+/*
+
+SoundFont 2 Player
+
+Parse SF2 files (presets, instruments, zones, generators and modulators) and
+turn a preset into a polyphonic sampler instrument.
+
+Each preset zone selects an instrument, and each instrument zone selects a
+sample. Instrument generators set absolute values, preset generators are
+added to them. Key and velocity ranges are intersected.
+
+The SF2 volume envelope is mapped onto the ADSR stages: hold is added to the
+decay time and the delay stage is not supported. The modulators are parsed
+but not applied, nor are the modulation envelope, LFOs, filter, scale tuning
+or the fixed key/velocity generators.
+
+*/
+//-----------------------------------------------------------------------------
+
+package main
+
+import (
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"io/ioutil"
+	"math"
+	"strings"
+)
+
+//-----------------------------------------------------------------------------
+// generator operators
+
+const (
+	sf2_start_offset        = 0
+	sf2_end_offset          = 1
+	sf2_loop_start_offset   = 2
+	sf2_loop_end_offset     = 3
+	sf2_start_coarse_offset = 4
+	sf2_end_coarse_offset   = 12
+	sf2_pan                 = 17
+	sf2_delay_vol_env       = 33
+	sf2_attack_vol_env      = 34
+	sf2_hold_vol_env        = 35
+	sf2_decay_vol_env       = 36
+	sf2_sustain_vol_env     = 37
+	sf2_release_vol_env     = 38
+	sf2_instrument          = 41
+	sf2_key_range           = 43
+	sf2_vel_range           = 44
+	sf2_loop_start_coarse   = 45
+	sf2_keynum              = 46
+	sf2_velocity            = 47
+	sf2_attenuation         = 48
+	sf2_loop_end_coarse     = 50
+	sf2_coarse_tune         = 51
+	sf2_fine_tune           = 52
+	sf2_sample_id           = 53
+	sf2_sample_modes        = 54
+	sf2_scale_tuning        = 56
+	sf2_exclusive_class     = 57
+	sf2_root_key            = 58
+	sf2_num_generators      = 61
+)
+
+// Return the default generator values.
+func sf2_default_generators() [sf2_num_generators]int32 {
+	var g [sf2_num_generators]int32
+	g[sf2_delay_vol_env] = -12000
+	g[sf2_attack_vol_env] = -12000
+	g[sf2_hold_vol_env] = -12000
+	g[sf2_decay_vol_env] = -12000
+	g[sf2_release_vol_env] = -12000
+	g[sf2_key_range] = 127 << 8
+	g[sf2_vel_range] = 127 << 8
+	g[sf2_keynum] = -1
+	g[sf2_velocity] = -1
+	g[sf2_scale_tuning] = 100
+	g[sf2_root_key] = -1
+	return g
+}
+
+// Return true if a generator value is a lo/hi range.
+func sf2_is_range(op int) bool {
+	return op == sf2_key_range || op == sf2_vel_range
+}
+
+// Return true if a generator value is an unsigned word.
+func sf2_is_unsigned(op int) bool {
+	switch op {
+	case sf2_instrument, sf2_sample_id, sf2_sample_modes, sf2_exclusive_class:
+		return true
+	}
+	return false
+}
+
+// Return the lo/hi values of a range generator.
+func sf2_range(x int32) (uint, uint) {
+	return uint(x & 0xff), uint((x >> 8) & 0xff)
+}
+
+// Return the intersection of two range generators.
+func sf2_range_intersect(a, b int32) int32 {
+	alo, ahi := sf2_range(a)
+	blo, bhi := sf2_range(b)
+	if blo > alo {
+		alo = blo
+	}
+	if bhi < ahi {
+		ahi = bhi
+	}
+	return int32(alo | (ahi << 8))
+}
+
+// Convert timecents to seconds.
+func sf2_timecents(tc int32) float32 {
+	return float32(math.Pow(2.0, float64(tc)/1200.0))
+}
+
+// Convert centibels of attenuation to a linear gain.
+func sf2_centibels(cb int32) float32 {
+	return float32(math.Pow(10.0, -float64(cb)/200.0))
+}
+
+//-----------------------------------------------------------------------------
+
+type SF2_Generator struct {
+	op     uint16 // generator operator
+	amount uint16 // raw amount (signed, unsigned or lo/hi range)
+}
+
+type SF2_Modulator struct {
+	src     uint16 // source
+	dst     uint16 // destination generator
+	amount  int16  // modulation amount
+	amt_src uint16 // amount source
+	trans   uint16 // transform
+}
+
+type SF2_Zone struct {
+	gen []SF2_Generator
+	mod []SF2_Modulator
+}
+
+// Return the value of the terminal generator (instrument or sample id) of a zone.
+func (z *SF2_Zone) terminal(op uint16) (int, bool) {
+	n := len(z.gen)
+	if n == 0 || z.gen[n-1].op != op {
+		return 0, false
+	}
+	return int(z.gen[n-1].amount), true
+}
+
+// Set the generator values of a zone.
+func (z *SF2_Zone) set(g *[sf2_num_generators]int32) {
+	for _, x := range z.gen {
+		if int(x.op) >= sf2_num_generators {
+			continue
+		}
+		if sf2_is_range(int(x.op)) || sf2_is_unsigned(int(x.op)) {
+			g[x.op] = int32(x.amount)
+		} else {
+			g[x.op] = int32(int16(x.amount))
+		}
+	}
+}
+
+type SF2_Instrument struct {
+	name   string
+	global *SF2_Zone  // global zone (may be nil)
+	zones  []SF2_Zone // zones with a sample
+}
+
+type SF2_Preset struct {
+	name    string
+	program uint       // midi program number
+	bank    uint       // midi bank number
+	global  *SF2_Zone  // global zone (may be nil)
+	zones   []SF2_Zone // zones with an instrument
+}
+
+type SF2_Sample struct {
+	name       string
+	start      int    // first sample
+	end        int    // first sample after the end
+	loop_start int    // first sample of the loop
+	loop_end   int    // first sample after the loop
+	rate       int    // sample rate
+	root       uint   // original midi pitch
+	correction int    // pitch correction in cents
+	link       uint16 // linked stereo sample
+	kind       uint16 // sample type
+}
+
+type SF2 struct {
+	name        string
+	data        []float32 // all sample data
+	presets     []SF2_Preset
+	instruments []SF2_Instrument
+	samples     []SF2_Sample
+}
+
+//-----------------------------------------------------------------------------
+
+// Return a zero terminated string.
+func sf2_string(b []byte) string {
+	if i := strings.IndexByte(string(b), 0); i >= 0 {
+		b = b[:i]
+	}
+	return strings.TrimSpace(string(b))
+}
+
+// Return the sub-chunks of a LIST chunk.
+func riff_chunks(buf []byte) map[string][]byte {
+	le := binary.LittleEndian
+	chunks := make(map[string][]byte)
+	for len(buf) >= 8 {
+		id := string(buf[0:4])
+		n := int(le.Uint32(buf[4:8]))
+		buf = buf[8:]
+		if n > len(buf) {
+			n = len(buf)
+		}
+		if id == "LIST" && n >= 4 {
+			id = string(buf[0:4])
+			chunks[id] = buf[4:n]
+		} else {
+			chunks[id] = buf[:n]
+		}
+		n += n & 1
+		if n > len(buf) {
+			n = len(buf)
+		}
+		buf = buf[n:]
+	}
+	return chunks
+}
+
+// Return the bag ranges of a bag chunk as (generator, modulator) indices.
+func sf2_bags(buf []byte) [][2]int {
+	le := binary.LittleEndian
+	bags := make([][2]int, len(buf)/4)
+	for i := range bags {
+		b := buf[i*4:]
+		bags[i] = [2]int{int(le.Uint16(b[0:2])), int(le.Uint16(b[2:4]))}
+	}
+	return bags
+}
+
+// Return the generators of a generator chunk.
+func sf2_generators(buf []byte) []SF2_Generator {
+	le := binary.LittleEndian
+	gen := make([]SF2_Generator, len(buf)/4)
+	for i := range gen {
+		b := buf[i*4:]
+		gen[i] = SF2_Generator{le.Uint16(b[0:2]), le.Uint16(b[2:4])}
+	}
+	return gen
+}
+
+// Return the modulators of a modulator chunk.
+func sf2_modulators(buf []byte) []SF2_Modulator {
+	le := binary.LittleEndian
+	mod := make([]SF2_Modulator, len(buf)/10)
+	for i := range mod {
+		b := buf[i*10:]
+		mod[i] = SF2_Modulator{
+			src:     le.Uint16(b[0:2]),
+			dst:     le.Uint16(b[2:4]),
+			amount:  int16(le.Uint16(b[4:6])),
+			amt_src: le.Uint16(b[6:8]),
+			trans:   le.Uint16(b[8:10]),
+		}
+	}
+	return mod
+}
+
+// Return the zones for bags [b0, b1).
+// The first zone is returned as the global zone if it has no terminal generator.
+func sf2_zones(bags [][2]int, gen []SF2_Generator, mod []SF2_Modulator, b0, b1 int, op uint16) (*SF2_Zone, []SF2_Zone, error) {
+	if b0 > b1 || b1 >= len(bags) {
+		return nil, nil, errors.New("bad bag index")
+	}
+	var global *SF2_Zone
+	var zones []SF2_Zone
+	for i := b0; i < b1; i++ {
+		g0, g1 := bags[i][0], bags[i+1][0]
+		m0, m1 := bags[i][1], bags[i+1][1]
+		if g0 > g1 || g1 > len(gen) || m0 > m1 || m1 > len(mod) {
+			return nil, nil, errors.New("bad zone index")
+		}
+		z := SF2_Zone{gen: gen[g0:g1], mod: mod[m0:m1]}
+		if _, ok := z.terminal(op); ok {
+			zones = append(zones, z)
+		} else if i == b0 {
+			global = &z
+		}
+	}
+	return global, zones, nil
+}
+
+//-----------------------------------------------------------------------------
+
+// Read an SF2 file.
+func load_sf2(path string) (*SF2, error) {
+	buf, err := ioutil.ReadFile(path)
+	if err != nil {
+		return nil, err
+	}
+	sf, err := decode_sf2(buf)
+	if err != nil {
+		return nil, fmt.Errorf("%s: %s", path, err)
+	}
+	return sf, nil
+}
+
+// Decode the contents of an SF2 file.
+func decode_sf2(buf []byte) (*SF2, error) {
+	le := binary.LittleEndian
+
+	if len(buf) < 12 || string(buf[0:4]) != "RIFF" || string(buf[8:12]) != "sfbk" {
+		return nil, errors.New("not a RIFF/sfbk file")
+	}
+	top := riff_chunks(buf[12:])
+	info := riff_chunks(top["INFO"])
+	sdta := riff_chunks(top["sdta"])
+	pdta := riff_chunks(top["pdta"])
+
+	sf := &SF2{name: sf2_string(info["INAM"])}
+
+	// 16 bit sample data
+	smpl := sdta["smpl"]
+	if smpl == nil {
+		return nil, errors.New("no sample data")
+	}
+	sf.data = make([]float32, len(smpl)/2)
+	for i := range sf.data {
+		sf.data[i] = float32(int16(le.Uint16(smpl[i*2:]))) / 32768.0
+	}
+
+	for _, id := range []string{"phdr", "pbag", "pmod", "pgen", "inst", "ibag", "imod", "igen", "shdr"} {
+		if pdta[id] == nil {
+			return nil, fmt.Errorf("no %s chunk", id)
+		}
+	}
+
+	// samples
+	shdr := pdta["shdr"]
+	for i := 0; i+46 <= len(shdr); i += 46 {
+		b := shdr[i : i+46]
+		s := SF2_Sample{
+			name:       sf2_string(b[0:20]),
+			start:      int(le.Uint32(b[20:24])),
+			end:        int(le.Uint32(b[24:28])),
+			loop_start: int(le.Uint32(b[28:32])),
+			loop_end:   int(le.Uint32(b[32:36])),
+			rate:       int(le.Uint32(b[36:40])),
+			root:       uint(b[40]),
+			correction: int(int8(b[41])),
+			link:       le.Uint16(b[42:44]),
+			kind:       le.Uint16(b[44:46]),
+		}
+		sf.samples = append(sf.samples, s)
+	}
+	if len(sf.samples) > 0 {
+		// drop the terminal record
+		sf.samples = sf.samples[:len(sf.samples)-1]
+	}
+
+	// instruments
+	ibag := sf2_bags(pdta["ibag"])
+	igen := sf2_generators(pdta["igen"])
+	imod := sf2_modulators(pdta["imod"])
+	inst := pdta["inst"]
+	for i := 0; i+44 <= len(inst); i += 22 {
+		b0 := int(le.Uint16(inst[i+20 : i+22]))
+		b1 := int(le.Uint16(inst[i+42 : i+44]))
+		global, zones, err := sf2_zones(ibag, igen, imod, b0, b1, sf2_sample_id)
+		if err != nil {
+			return nil, err
+		}
+		for _, z := range zones {
+			if id, _ := z.terminal(sf2_sample_id); id >= len(sf.samples) {
+				return nil, errors.New("bad sample id")
+			}
+		}
+		sf.instruments = append(sf.instruments, SF2_Instrument{
+			name:   sf2_string(inst[i : i+20]),
+			global: global,
+			zones:  zones,
+		})
+	}
+
+	// presets
+	pbag := sf2_bags(pdta["pbag"])
+	pgen := sf2_generators(pdta["pgen"])
+	pmod := sf2_modulators(pdta["pmod"])
+	phdr := pdta["phdr"]
+	for i := 0; i+76 <= len(phdr); i += 38 {
+		b0 := int(le.Uint16(phdr[i+24 : i+26]))
+		b1 := int(le.Uint16(phdr[i+62 : i+64]))
+		global, zones, err := sf2_zones(pbag, pgen, pmod, b0, b1, sf2_instrument)
+		if err != nil {
+			return nil, err
+		}
+		for _, z := range zones {
+			if id, _ := z.terminal(sf2_instrument); id >= len(sf.instruments) {
+				return nil, errors.New("bad instrument id")
+			}
+		}
+		sf.presets = append(sf.presets, SF2_Preset{
+			name:    sf2_string(phdr[i : i+20]),
+			program: uint(le.Uint16(phdr[i+20 : i+22])),
+			bank:    uint(le.Uint16(phdr[i+22 : i+24])),
+			global:  global,
+			zones:   zones,
+		})
+	}
+
+	return sf, nil
+}
+
+//-----------------------------------------------------------------------------
+
+// Return the preset for a midi bank and program number.
+func (sf *SF2) Preset(bank, program uint) (*SF2_Preset, error) {
+	for i := range sf.presets {
+		p := &sf.presets[i]
+		if p.bank == bank && p.program == program {
+			return p, nil
+		}
+	}
+	return nil, fmt.Errorf("no preset for bank %d program %d", bank, program)
+}
+
+// Return a sampler region for resolved generator values.
+func (sf *SF2) region(g *[sf2_num_generators]int32, rate int) (*SamplerRegion, error) {
+	smp := &sf.samples[g[sf2_sample_id]]
+
+	// sample and loop points with the address offsets
+	start := smp.start + int(g[sf2_start_offset]) + int(g[sf2_start_coarse_offset])*32768
+	end := smp.end + int(g[sf2_end_offset]) + int(g[sf2_end_coarse_offset])*32768
+	loop_start := smp.loop_start + int(g[sf2_loop_start_offset]) + int(g[sf2_loop_start_coarse])*32768
+	loop_end := smp.loop_end + int(g[sf2_loop_end_offset]) + int(g[sf2_loop_end_coarse])*32768
+	if start < 0 || end > len(sf.data) || end-start < 2 {
+		return nil, fmt.Errorf("%s: bad sample points", smp.name)
+	}
+
+	root := smp.root
+	if g[sf2_root_key] >= 0 {
+		root = uint(g[sf2_root_key])
+	}
+	if root > 127 {
+		// 255 is unpitched
+		root = 60
+	}
+
+	s := NewSampleData(sf.data, smp.rate, root)
+	s.start = start
+	s.end = end
+	s.tune = float32((g[sf2_coarse_tune] * 100) + g[sf2_fine_tune] + int32(smp.correction))
+
+	// bad loop points play without a loop
+	switch g[sf2_sample_modes] & 3 {
+	case 1:
+		s.SetLoop(loop_forward, loop_start, loop_end)
+	case 3:
+		s.SetLoop(loop_sustain, loop_start, loop_end)
+	}
+
+	// volume envelope
+	a := sf2_timecents(g[sf2_attack_vol_env])
+	d := sf2_timecents(g[sf2_hold_vol_env]) + sf2_timecents(g[sf2_decay_vol_env])
+	sus := g[sf2_sustain_vol_env]
+	if sus < 0 {
+		sus = 0
+	}
+	r := sf2_timecents(g[sf2_release_vol_env])
+	rg, err := NewSamplerRegion(s, a, d, sf2_centibels(sus), r, rate)
+	if err != nil {
+		return nil, fmt.Errorf("%s: %s", smp.name, err)
+	}
+
+	rg.lokey, rg.hikey = sf2_range(g[sf2_key_range])
+	rg.lovel, rg.hivel = sf2_range(g[sf2_vel_range])
+	att := g[sf2_attenuation]
+	if att < 0 {
+		att = 0
+	}
+	rg.gain = sf2_centibels(att)
+	rg.pan = float32(g[sf2_pan]) / 500.0
+	if rg.pan < -1.0 {
+		rg.pan = -1.0
+	} else if rg.pan > 1.0 {
+		rg.pan = 1.0
+	}
+	// an exclusive class turns off its own notes
+	rg.group = int(g[sf2_exclusive_class])
+	rg.off_by = rg.group
+	return rg, nil
+}
+
+// Return the sampler regions of a preset.
+func (sf *SF2) regions(p *SF2_Preset, rate int) ([]*SamplerRegion, error) {
+	var regions []*SamplerRegion
+	for _, pz := range p.zones {
+		// preset generators are offsets from the instrument values
+		var pg [sf2_num_generators]int32
+		pg[sf2_key_range] = 127 << 8
+		pg[sf2_vel_range] = 127 << 8
+		if p.global != nil {
+			p.global.set(&pg)
+		}
+		pz.set(&pg)
+		id, _ := pz.terminal(sf2_instrument)
+		inst := &sf.instruments[id]
+		for _, iz := range inst.zones {
+			g := sf2_default_generators()
+			if inst.global != nil {
+				inst.global.set(&g)
+			}
+			iz.set(&g)
+			for op := range g {
+				switch {
+				case sf2_is_range(op):
+					g[op] = sf2_range_intersect(g[op], pg[op])
+				case op == sf2_instrument || op == sf2_sample_id || op == sf2_sample_modes ||
+					op == sf2_exclusive_class || op == sf2_root_key || op == sf2_keynum || op == sf2_velocity ||
+					(op >= sf2_start_offset && op <= sf2_loop_end_offset) || op == sf2_start_coarse_offset ||
+					op == sf2_end_coarse_offset || op == sf2_loop_start_coarse || op == sf2_loop_end_coarse:
+					// not valid at the preset level
+				default:
+					g[op] += pg[op]
+				}
+			}
+			lokey, hikey := sf2_range(g[sf2_key_range])
+			lovel, hivel := sf2_range(g[sf2_vel_range])
+			if lokey > hikey || lovel > hivel {
+				// the preset and instrument ranges don't overlap
+				continue
+			}
+			rg, err := sf.region(&g, rate)
+			if err != nil {
+				return nil, err
+			}
+			regions = append(regions, rg)
+		}
+	}
+	return regions, nil
+}
+
+// Return a polyphonic sampler instrument for a preset.
+func (sf *SF2) Instrument(bank, program uint, n int, rate int) (*SamplerInstrument, error) {
+	p, err := sf.Preset(bank, program)
+	if err != nil {
+		return nil, err
+	}
+	regions, err := sf.regions(p, rate)
+	if err != nil {
+		return nil, fmt.Errorf("%s: %s", p.name, err)
+	}
+	return NewSamplerInstrument(regions, n, rate)
+}
+
+//-----------------------------------------------------------------------------
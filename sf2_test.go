@@ -0,0 +1,55 @@
+//-----------------------------------------------------------------------------
+/*
+
+SF2 Soundfont Tests
+
+*/
+//-----------------------------------------------------------------------------
+
+package main
+
+import "testing"
+
+//-----------------------------------------------------------------------------
+
+// A stereo pair of zones in an exclusive class (e.g. a GM hi-hat) should
+// sound both zones for a note, and both should be stopped by the next note.
+func TestSF2_ExclusiveClassPair(t *testing.T) {
+	const rate = 44100
+	sf := &SF2{
+		data: make([]float32, 2000),
+		samples: []SF2_Sample{
+			{name: "hat L", start: 0, end: 1000, rate: rate, root: 60},
+			{name: "hat R", start: 1000, end: 2000, rate: rate, root: 60},
+		},
+	}
+	var regions []*SamplerRegion
+	for i, pan := range []int16{-500, 500} {
+		z := SF2_Zone{
+			gen: []SF2_Generator{
+				{sf2_pan, uint16(pan)},
+				{sf2_exclusive_class, 1},
+				{sf2_sample_id, uint16(i)},
+			},
+		}
+		g := sf2_default_generators()
+		z.set(&g)
+		rg, err := sf.region(&g, rate)
+		if err != nil {
+			t.Fatal(err)
+		}
+		regions = append(regions, rg)
+	}
+	si, err := NewSamplerInstrument(regions, 8, rate)
+	if err != nil {
+		t.Fatal(err)
+	}
+	for i := 0; i < 2; i++ {
+		si.NoteOn(42, 100)
+		if n := si.Active(); n != 2 {
+			t.Fatalf("note %d: %d active voices, want 2", i, n)
+		}
+	}
+}
+
+//-----------------------------------------------------------------------------
@@ -0,0 +1,352 @@
+//-----------------------------------------------------------------------------
+/*
+
+SFZ Instruments
+
+Load an SFZ text file as a polyphonic sampler instrument. Opcodes in the
+<control>, <global>, <master> and <group> headers are inherited by the
+<region> headers that follow them.
+
+Supported opcodes:
+
+sample, default_path, key, lokey, hikey, lovel, hivel, pitch_keycenter,
+transpose, tune, volume, pan, offset, end, loop_mode, loop_start, loop_end,
+ampeg_attack, ampeg_decay, ampeg_sustain, ampeg_release, group, off_by
+
+Other opcodes, #define and #include are ignored. Samples are WAV files.
+
+*/
+//-----------------------------------------------------------------------------
+
+package main
+
+import (
+	"errors"
+	"fmt"
+	"io/ioutil"
+	"math"
+	"path/filepath"
+	"regexp"
+	"strconv"
+	"strings"
+)
+
+//-----------------------------------------------------------------------------
+
+// sfz opcodes for a region
+type sfz_opcodes map[string]string
+
+// headers and opcodes
+var sfz_token = regexp.MustCompile(`<(\w+)>|(\w+)=`)
+
+// comments
+var sfz_comment = regexp.MustCompile(`(?s)/\*.*?\*/|//[^\n]*`)
+
+// Return the regions of an SFZ file with their inherited opcodes.
+func decode_sfz(text string) []sfz_opcodes {
+	text = sfz_comment.ReplaceAllString(text, "")
+	var regions []sfz_opcodes
+	var header string
+	// opcodes at each header level
+	levels := map[string]sfz_opcodes{}
+	order := []string{"control", "global", "master", "group", "region"}
+	idx := sfz_token.FindAllStringSubmatchIndex(text, -1)
+	for i, m := range idx {
+		if m[2] >= 0 {
+			// header: reset this level and the levels below it
+			header = text[m[2]:m[3]]
+			reset := false
+			for _, h := range order {
+				if h == header {
+					reset = true
+				}
+				if reset {
+					delete(levels, h)
+				}
+			}
+			ops := sfz_opcodes{}
+			if header == "region" {
+				// inherit the opcodes of the enclosing headers
+				for _, h := range order {
+					for k, v := range levels[h] {
+						ops[k] = v
+					}
+				}
+				regions = append(regions, ops)
+			}
+			levels[header] = ops
+			continue
+		}
+		// opcode: the value runs up to the next token
+		end := len(text)
+		if i+1 < len(idx) {
+			end = idx[i+1][0]
+		}
+		op := text[m[4]:m[5]]
+		val := strings.TrimSpace(text[m[1]:end])
+		if op != "sample" {
+			// only sample names may contain spaces
+			if f := strings.Fields(val); len(f) > 0 {
+				val = f[0]
+			}
+		}
+		if ops := levels[header]; ops != nil {
+			ops[op] = val
+		}
+	}
+	return regions
+}
+
+//-----------------------------------------------------------------------------
+
+// Return a midi note number from a number or a note name (c4 is 60).
+func sfz_key(s string) (uint, error) {
+	if n, err := strconv.Atoi(s); err == nil {
+		if n < 0 || n > 127 {
+			return 0, fmt.Errorf("bad key %q", s)
+		}
+		return uint(n), nil
+	}
+	name := s
+	s = strings.ToLower(s)
+	if len(s) < 2 || s[0] == ' ' {
+		return 0, fmt.Errorf("bad key %q", name)
+	}
+	i := strings.IndexByte("c d ef g a b", s[0])
+	if i < 0 {
+		return 0, fmt.Errorf("bad key %q", name)
+	}
+	s = s[1:]
+	if s[0] == '#' {
+		i++
+		s = s[1:]
+	} else if s[0] == 'b' && len(s) > 1 {
+		i--
+		s = s[1:]
+	}
+	oct, err := strconv.Atoi(s)
+	if err != nil {
+		return 0, fmt.Errorf("bad key %q", name)
+	}
+	n := ((oct + 1) * NOTES_IN_OCTAVE) + i
+	if n < 0 || n > 127 {
+		return 0, fmt.Errorf("bad key %q", name)
+	}
+	return uint(n), nil
+}
+
+// Return the float value of an opcode, or a default value.
+func (ops sfz_opcodes) float(op string, x float32) (float32, error) {
+	s, ok := ops[op]
+	if !ok {
+		return x, nil
+	}
+	f, err := strconv.ParseFloat(s, 32)
+	if err != nil {
+		return 0, fmt.Errorf("bad %s value %q", op, s)
+	}
+	return float32(f), nil
+}
+
+// Return the integer value of an opcode, or a default value.
+func (ops sfz_opcodes) int(op string, x int) (int, error) {
+	s, ok := ops[op]
+	if !ok {
+		return x, nil
+	}
+	i, err := strconv.Atoi(s)
+	if err != nil {
+		return 0, fmt.Errorf("bad %s value %q", op, s)
+	}
+	return i, nil
+}
+
+// Return the key value of an opcode, or a default value.
+func (ops sfz_opcodes) key(op string, x uint) (uint, error) {
+	s, ok := ops[op]
+	if !ok {
+		return x, nil
+	}
+	return sfz_key(s)
+}
+
+//-----------------------------------------------------------------------------
+
+// Return a sampler region for the opcodes of an SFZ region.
+func sfz_region(ops sfz_opcodes, dir string, cache map[string]*SampleData, rate int) (*SamplerRegion, error) {
+
+	// load the sample
+	name, ok := ops["sample"]
+	if !ok {
+		return nil, errors.New("no sample")
+	}
+	path := filepath.Join(dir, filepath.FromSlash(strings.Replace(ops["default_path"]+name, "\\", "/", -1)))
+	src, ok := cache[path]
+	if !ok {
+		var err error
+		src, err = load_sample(path, 60)
+		if err != nil {
+			return nil, err
+		}
+		cache[path] = src
+	}
+
+	// each region has its own copy of the play and loop settings
+	s := *src
+	var err error
+	lokey, hikey := uint(0), uint(127)
+	s.root = 60
+	if _, ok := ops["key"]; ok {
+		if lokey, err = ops.key("key", 0); err != nil {
+			return nil, err
+		}
+		hikey = lokey
+		s.root = lokey
+	}
+	if lokey, err = ops.key("lokey", lokey); err != nil {
+		return nil, err
+	}
+	if hikey, err = ops.key("hikey", hikey); err != nil {
+		return nil, err
+	}
+	if ops["pitch_keycenter"] == "sample" {
+		// use the root note from the sample file
+		s.root = src.root
+	} else if s.root, err = ops.key("pitch_keycenter", s.root); err != nil {
+		return nil, err
+	}
+	lovel, err := ops.int("lovel", 0)
+	if err != nil {
+		return nil, err
+	}
+	hivel, err := ops.int("hivel", 127)
+	if err != nil {
+		return nil, err
+	}
+
+	// tuning
+	transpose, err := ops.int("transpose", 0)
+	if err != nil {
+		return nil, err
+	}
+	tune, err := ops.int("tune", 0)
+	if err != nil {
+		return nil, err
+	}
+	s.tune = float32((transpose * 100) + tune)
+
+	// play region (end is inclusive)
+	if s.start, err = ops.int("offset", 0); err != nil {
+		return nil, err
+	}
+	end, err := ops.int("end", s.end-1)
+	if err != nil {
+		return nil, err
+	}
+	s.end = end + 1
+	if s.start < 0 || s.end > len(s.data) || s.end-s.start < 2 {
+		return nil, fmt.Errorf("%s: bad offset/end", name)
+	}
+
+	// loop (end is inclusive)
+	mode := s.mode
+	oneshot := false
+	switch ops["loop_mode"] {
+	case "":
+	case "no_loop":
+		mode = loop_none
+	case "one_shot":
+		mode = loop_none
+		oneshot = true
+	case "loop_continuous":
+		mode = loop_forward
+	case "loop_sustain":
+		mode = loop_sustain
+	default:
+		return nil, fmt.Errorf("bad loop_mode %q", ops["loop_mode"])
+	}
+	loop_start, err := ops.int("loop_start", s.loop_start)
+	if err != nil {
+		return nil, err
+	}
+	loop_end, err := ops.int("loop_end", s.loop_end-1)
+	if err != nil {
+		return nil, err
+	}
+	if mode != loop_none && loop_end >= s.end {
+		// keep the loop inside the play region
+		loop_end = s.end - 1
+	}
+	if err := s.SetLoop(mode, loop_start, loop_end+1); err != nil {
+		return nil, fmt.Errorf("%s: %s", name, err)
+	}
+
+	// amplitude envelope
+	a, err := ops.float("ampeg_attack", 0)
+	if err != nil {
+		return nil, err
+	}
+	d, err := ops.float("ampeg_decay", 0)
+	if err != nil {
+		return nil, err
+	}
+	sus, err := ops.float("ampeg_sustain", 100)
+	if err != nil {
+		return nil, err
+	}
+	r, err := ops.float("ampeg_release", 0.001)
+	if err != nil {
+		return nil, err
+	}
+	rg, err := NewSamplerRegion(&s, a, d, sus/100.0, r, rate)
+	if err != nil {
+		return nil, fmt.Errorf("%s: %s", name, err)
+	}
+
+	rg.lokey, rg.hikey = lokey, hikey
+	rg.lovel, rg.hivel = uint(lovel), uint(hivel)
+	rg.oneshot = oneshot
+	volume, err := ops.float("volume", 0)
+	if err != nil {
+		return nil, err
+	}
+	rg.gain = float32(math.Pow(10.0, float64(volume)/20.0))
+	pan, err := ops.float("pan", 0)
+	if err != nil {
+		return nil, err
+	}
+	rg.pan = pan / 100.0
+	if rg.pan < -1.0 {
+		rg.pan = -1.0
+	} else if rg.pan > 1.0 {
+		rg.pan = 1.0
+	}
+	if rg.group, err = ops.int("group", 0); err != nil {
+		return nil, err
+	}
+	if rg.off_by, err = ops.int("off_by", 0); err != nil {
+		return nil, err
+	}
+	return rg, nil
+}
+
+// Load an SFZ file as a sampler instrument with n voices.
+func load_sfz(path string, n int, rate int) (*SamplerInstrument, error) {
+	buf, err := ioutil.ReadFile(path)
+	if err != nil {
+		return nil, err
+	}
+	dir := filepath.Dir(path)
+	cache := map[string]*SampleData{}
+	var regions []*SamplerRegion
+	for i, ops := range decode_sfz(string(buf)) {
+		rg, err := sfz_region(ops, dir, cache, rate)
+		if err != nil {
+			return nil, fmt.Errorf("%s: region %d: %s", path, i, err)
+		}
+		regions = append(regions, rg)
+	}
+	return NewSamplerInstrument(regions, n, rate)
+}
+
+//-----------------------------------------------------------------------------
@@ -0,0 +1,204 @@
+//-----------------------------------------------------------------------------
+/*
+
+Waveshaping and Wavefolding
+
+A nonlinear shaping stage: tanh saturation, cubic soft clipping, Chebyshev
+polynomial harmonic generation and a multi-stage (Serge style) wavefolder.
+
+The nonlinearities generate harmonics that can alias, so the shaper can run
+internally at 2x, 4x or 8x the sample rate. The input is upsampled by linear
+interpolation and lowpass filtered, shaped, then lowpass filtered again and
+decimated.
+
+*/
+//-----------------------------------------------------------------------------
+
+package main
+
+import (
+	"errors"
+	"math"
+)
+
+//-----------------------------------------------------------------------------
+
+type ShaperType int
+
+const (
+	shape_tanh ShaperType = iota
+	shape_softclip
+	shape_chebyshev
+	shape_fold
+)
+
+var shape_txt = map[ShaperType]string{
+	shape_tanh:      "tanh",
+	shape_softclip:  "softclip",
+	shape_chebyshev: "chebyshev",
+	shape_fold:      "fold",
+}
+
+func (x ShaperType) String() string {
+	return shape_txt[x]
+}
+
+//-----------------------------------------------------------------------------
+
+// Return a cubic soft clip of x.
+func softclip(x float32) float32 {
+	if x <= -1.0 {
+		return -2.0 / 3.0
+	}
+	if x >= 1.0 {
+		return 2.0 / 3.0
+	}
+	return x - (x * x * x / 3.0)
+}
+
+// Return x folded back into -1..1 (a triangle transfer function).
+func tri_fold(x float32) float32 {
+	// period of 4: x = -1..1 is unchanged
+	p := float64(x+1.0) / 4.0
+	p -= math.Floor(p)
+	return float32(1.0 - math.Abs((4.0*p)-2.0))
+}
+
+// Return the weighted sum of Chebyshev polynomials T1..Tn at x (clamped to -1..1).
+func chebyshev(x float32, weights []float32) float32 {
+	if x < -1.0 {
+		x = -1.0
+	} else if x > 1.0 {
+		x = 1.0
+	}
+	var y float32
+	t0, t1 := float32(1.0), x
+	for _, w := range weights {
+		y += w * t1
+		t0, t1 = t1, (2.0*x*t1)-t0
+	}
+	return y
+}
+
+//-----------------------------------------------------------------------------
+
+// 4th order butterworth lowpass
+type butterworth4 [2]Biquad
+
+func (f *butterworth4) set(fc float32, rate int) {
+	f[0].SetLowPass(fc, 0.5412, rate)
+	f[1].SetLowPass(fc, 1.3066, rate)
+}
+
+func (f *butterworth4) process(x float32) float32 {
+	return f[1].Process(f[0].Process(x))
+}
+
+//-----------------------------------------------------------------------------
+
+type Shaper struct {
+	in     Generator    // input (may be nil if using Process)
+	kind   ShaperType   // shaping function
+	drive  float32      // input gain
+	bias   float32      // input offset (adds even harmonics)
+	cheb   []float32    // chebyshev weights for T1..Tn
+	stages int          // wavefolder stages
+	os     int          // oversampling factor
+	up     butterworth4 // upsampling filter
+	down   butterworth4 // downsampling filter
+	x1     float32      // previous input
+}
+
+// Return a waveshaper. Oversampling may be 1 (none), 2, 4 or 8.
+func NewShaper(in Generator, kind ShaperType, drive float32, os int, rate int) (*Shaper, error) {
+	switch os {
+	case 1, 2, 4, 8:
+	default:
+		return nil, errors.New("bad oversampling factor")
+	}
+	s := &Shaper{
+		in:     in,
+		kind:   kind,
+		drive:  drive,
+		cheb:   []float32{1.0},
+		stages: 1,
+		os:     os,
+	}
+	if os > 1 {
+		// cut off below the original nyquist frequency
+		fc := 0.45 * float32(rate)
+		s.up.set(fc, rate*os)
+		s.down.set(fc, rate*os)
+	}
+	return s, nil
+}
+
+// Set the input gain.
+func (s *Shaper) SetDrive(drive float32) {
+	s.drive = drive
+}
+
+// Set the input offset.
+func (s *Shaper) SetBias(bias float32) {
+	s.bias = bias
+}
+
+// Set the Chebyshev polynomial weights (for T1, T2, ... Tn).
+func (s *Shaper) SetChebyshev(weights []float32) {
+	s.cheb = weights
+}
+
+// Set the number of wavefolder stages.
+func (s *Shaper) SetStages(n int) {
+	if n < 1 {
+		n = 1
+	}
+	s.stages = n
+}
+
+//-----------------------------------------------------------------------------
+
+// Return the shaping function of x.
+func (s *Shaper) shape(x float32) float32 {
+	x = (x * s.drive) + s.bias
+	switch s.kind {
+	case shape_tanh:
+		return float32(math.Tanh(float64(x)))
+	case shape_softclip:
+		return 1.5 * softclip(x)
+	case shape_chebyshev:
+		return chebyshev(x, s.cheb)
+	case shape_fold:
+		// each stage folds the output of the previous one
+		for i := 0; i < s.stages; i++ {
+			x = tri_fold(x)
+			if i != s.stages-1 {
+				x *= s.drive
+			}
+		}
+		return x
+	}
+	panic("bad shaper type")
+}
+
+// Shape an input sample.
+func (s *Shaper) Process(x float32) float32 {
+	if s.os == 1 {
+		return s.shape(x)
+	}
+	var y float32
+	k := 1.0 / float32(s.os)
+	for i := 1; i <= s.os; i++ {
+		xi := s.x1 + (float32(i) * k * (x - s.x1))
+		y = s.down.process(s.shape(s.up.process(xi)))
+	}
+	s.x1 = x
+	return y
+}
+
+// Return a shaped sample from the input generator.
+func (s *Shaper) Sample() float32 {
+	return s.Process(s.in.Sample())
+}
+
+//-----------------------------------------------------------------------------
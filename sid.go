@@ -0,0 +1,433 @@
+//-----------------------------------------------------------------------------
+/*
+
+C64 SID Emulation
+
+A register level emulation of the MOS 6581/8580 SID: three voices, each
+with a 24 bit phase accumulator, triangle, sawtooth, pulse and noise
+waveforms, ring modulation, hard sync and an ADSR envelope, followed by the
+multimode (lowpass, bandpass, highpass) resonant filter.
+
+The oscillators and envelopes are clocked at the PAL clock rate and averaged
+down to the sample rate. The filter is a state variable filter run at the
+sample rate with a linear (8580 style) cutoff curve. Combined waveforms are
+approximated by ANDing the selected waveforms.
+
+The voices can be driven from MIDI with SID voices.
+
+*/
+//-----------------------------------------------------------------------------
+
+package main
+
+import (
+	"errors"
+	"math"
+)
+
+//-----------------------------------------------------------------------------
+
+const sid_clock = 985248 // PAL clock
+
+// voice control register bits
+const (
+	sid_gate     = 0x01
+	sid_sync     = 0x02
+	sid_ring     = 0x04
+	sid_test     = 0x08
+	sid_triangle = 0x10
+	sid_sawtooth = 0x20
+	sid_pulse    = 0x40
+	sid_noise    = 0x80
+)
+
+// filter mode register bits
+const (
+	sid_lowpass  = 0x10
+	sid_bandpass = 0x20
+	sid_highpass = 0x40
+	sid_3off     = 0x80
+)
+
+// envelope rate counter periods in cycles
+var sid_rate_period = [16]uint16{
+	9, 32, 63, 95, 149, 220, 267, 313, 392, 977, 1954, 3126, 3907, 11720, 19532, 31251,
+}
+
+//-----------------------------------------------------------------------------
+
+type sid_envelope struct {
+	state   ADSRState
+	attack  uint8
+	decay   uint8
+	sustain uint8
+	release uint8
+	gate    bool
+	rate    uint16 // rate counter
+	exp     uint8  // exponential counter
+	level   uint8  // envelope level (0..255)
+}
+
+// Set the gate bit.
+func (e *sid_envelope) set_gate(gate bool) {
+	if gate && !e.gate {
+		e.state = attack
+	} else if !gate && e.gate {
+		e.state = release
+	}
+	e.gate = gate
+}
+
+// Return the exponential counter period for the current level.
+func (e *sid_envelope) exp_period() uint8 {
+	switch {
+	case e.level > 93:
+		return 1
+	case e.level > 54:
+		return 2
+	case e.level > 26:
+		return 4
+	case e.level > 14:
+		return 8
+	case e.level > 6:
+		return 16
+	case e.level > 0:
+		return 30
+	}
+	return 1
+}
+
+// clocked every cycle
+func (e *sid_envelope) clock() {
+	var period uint16
+	switch e.state {
+	case attack:
+		period = sid_rate_period[e.attack]
+	case decay, sustain:
+		period = sid_rate_period[e.decay]
+	case release:
+		period = sid_rate_period[e.release]
+	default:
+		return
+	}
+	e.rate++
+	if e.rate < period {
+		return
+	}
+	e.rate = 0
+	if e.state == attack {
+		if e.level < 0xff {
+			e.level++
+		}
+		if e.level == 0xff {
+			e.state = decay
+		}
+		return
+	}
+	// decay and release are exponential
+	e.exp++
+	if e.exp < e.exp_period() {
+		return
+	}
+	e.exp = 0
+	switch e.state {
+	case decay, sustain:
+		if e.level > e.sustain*17 {
+			e.level--
+		}
+	case release:
+		if e.level > 0 {
+			e.level--
+		}
+	}
+}
+
+//-----------------------------------------------------------------------------
+
+type sid_voice struct {
+	freq uint16 // frequency register
+	pw   uint16 // pulse width register (12 bits)
+	ctrl uint8  // control register
+	acc  uint32 // 24 bit phase accumulator
+	msb  bool   // accumulator msb rose on the last clock
+	lfsr uint32 // 23 bit noise shift register
+	env  sid_envelope
+	out  float32 // output accumulated over the cycles of a sample
+}
+
+func (v *sid_voice) write(reg int, val uint8) {
+	switch reg {
+	case 0:
+		v.freq = (v.freq & 0xff00) | uint16(val)
+	case 1:
+		v.freq = (v.freq & 0xff) | (uint16(val) << 8)
+	case 2:
+		v.pw = (v.pw & 0xf00) | uint16(val)
+	case 3:
+		v.pw = (v.pw & 0xff) | (uint16(val&15) << 8)
+	case 4:
+		v.ctrl = val
+		if val&sid_test != 0 {
+			v.acc = 0
+			v.lfsr = 0x7ffff8
+		}
+		v.env.set_gate(val&sid_gate != 0)
+	case 5:
+		v.env.attack = val >> 4
+		v.env.decay = val & 15
+	case 6:
+		v.env.sustain = val >> 4
+		v.env.release = val & 15
+	}
+}
+
+// Step the phase accumulator.
+func (v *sid_voice) clock() {
+	if v.ctrl&sid_test != 0 {
+		v.msb = false
+		return
+	}
+	prev := v.acc
+	v.acc = (v.acc + uint32(v.freq)) & 0xffffff
+	v.msb = prev&0x800000 == 0 && v.acc&0x800000 != 0
+	// the noise register is clocked by bit 19
+	if prev&0x080000 == 0 && v.acc&0x080000 != 0 {
+		fb := ((v.lfsr >> 22) ^ (v.lfsr >> 17)) & 1
+		v.lfsr = ((v.lfsr << 1) | fb) & 0x7fffff
+	}
+}
+
+// Return the 12 bit waveform output. src is the sync/ring source voice.
+func (v *sid_voice) wave(src *sid_voice) uint16 {
+	out := uint16(0xfff)
+	if v.ctrl&0xf0 == 0 {
+		return 0
+	}
+	if v.ctrl&sid_triangle != 0 {
+		acc := v.acc
+		msb := acc & 0x800000
+		if v.ctrl&sid_ring != 0 {
+			msb ^= src.acc & 0x800000
+		}
+		if msb != 0 {
+			acc = ^acc
+		}
+		out &= uint16(acc>>11) & 0xfff
+	}
+	if v.ctrl&sid_sawtooth != 0 {
+		out &= uint16(v.acc >> 12)
+	}
+	if v.ctrl&sid_pulse != 0 {
+		if v.ctrl&sid_test == 0 && uint16(v.acc>>12) < v.pw {
+			out = 0
+		}
+	}
+	if v.ctrl&sid_noise != 0 {
+		r := v.lfsr
+		n := ((r >> 11) & 0x800) | ((r >> 10) & 0x400) | ((r >> 7) & 0x200) |
+			((r >> 5) & 0x100) | ((r >> 4) & 0x080) | ((r >> 1) & 0x040) |
+			((r << 1) & 0x020) | ((r << 2) & 0x010)
+		out &= uint16(n)
+	}
+	return out
+}
+
+//-----------------------------------------------------------------------------
+
+type SID struct {
+	voice   [3]sid_voice
+	cutoff  uint16 // filter cutoff register (11 bits)
+	res     uint8  // filter resonance (0..15)
+	route   uint8  // voices routed through the filter
+	mode    uint8  // filter mode and volume register
+	lp, bp  float32
+	kcycles float64 // cycles per sample
+	ncycles float64 // cycles owed to the next sample
+	dc      dcblock
+	rate    int
+}
+
+// Return a SID chip.
+func NewSID(rate int) *SID {
+	s := &SID{
+		kcycles: float64(sid_clock) / float64(rate),
+		rate:    rate,
+	}
+	for i := range s.voice {
+		s.voice[i].lfsr = 0x7ffff8
+	}
+	return s
+}
+
+// Write a SID register (0..24, $D400..$D418).
+func (s *SID) Write(reg uint8, val uint8) {
+	switch {
+	case reg < 21:
+		s.voice[reg/7].write(int(reg%7), val)
+	case reg == 21:
+		s.cutoff = (s.cutoff & 0x7f8) | uint16(val&7)
+	case reg == 22:
+		s.cutoff = (s.cutoff & 7) | (uint16(val) << 3)
+	case reg == 23:
+		s.res = val >> 4
+		s.route = val & 15
+	case reg == 24:
+		s.mode = val
+	}
+}
+
+// Run the filter on an input sample.
+func (s *SID) filter(x float32) float32 {
+	// linear cutoff curve from 30 Hz to 12 kHz
+	fc := 30.0 + (float64(s.cutoff) * (12000.0 - 30.0) / 2047.0)
+	if fc > 0.45*float64(s.rate) {
+		fc = 0.45 * float64(s.rate)
+	}
+	g := float32(math.Tan(math.Pi * fc / float64(s.rate)))
+	k := float32(1.4 - (float64(s.res) / 15.0 * 1.3))
+	// trapezoidal state variable filter
+	hp := (x - ((k + g) * s.bp) - s.lp) / (1.0 + (g * (g + k)))
+	bp := (g * hp) + s.bp
+	s.bp = (g * hp) + bp
+	lp := (g * bp) + s.lp
+	s.lp = (g * bp) + lp
+	var y float32
+	if s.mode&sid_lowpass != 0 {
+		y += lp
+	}
+	if s.mode&sid_bandpass != 0 {
+		y += bp
+	}
+	if s.mode&sid_highpass != 0 {
+		y += hp
+	}
+	return y
+}
+
+func (s *SID) Sample() float32 {
+	s.ncycles += s.kcycles
+	n := int(s.ncycles)
+	s.ncycles -= float64(n)
+	for i := range s.voice {
+		s.voice[i].out = 0
+	}
+	for c := 0; c < n; c++ {
+		for i := range s.voice {
+			s.voice[i].clock()
+		}
+		for i := range s.voice {
+			v := &s.voice[i]
+			// voice 1 is synced by voice 3, voice 2 by 1, voice 3 by 2
+			src := &s.voice[(i+2)%3]
+			if v.ctrl&sid_sync != 0 && src.msb {
+				v.acc = 0
+			}
+			v.env.clock()
+			w := float32(v.wave(src)) - 2048.0
+			v.out += w * float32(v.env.level)
+		}
+	}
+	var direct, filtered float32
+	k := 1.0 / (2048.0 * 255.0 * float32(n))
+	for i := range s.voice {
+		y := s.voice[i].out * k
+		if s.route&(1<<uint(i)) != 0 {
+			filtered += y
+		} else if i != 2 || s.mode&sid_3off == 0 {
+			direct += y
+		}
+	}
+	vol := float32(s.mode&15) / 15.0
+	y := (direct + s.filter(filtered)) * vol / 3.0
+	return s.dc.tick(y)
+}
+
+//-----------------------------------------------------------------------------
+// MIDI voices
+
+type SID_Voice struct {
+	sid  *SID
+	v    uint8 // voice number (0..2)
+	wave uint8 // waveform control bits
+	note uint  // note being played
+}
+
+// Return a voice that drives one of the SID voices.
+func NewSID_Voice(sid *SID, v int) (*SID_Voice, error) {
+	if v < 0 || v > 2 {
+		return nil, errors.New("bad sid voice")
+	}
+	sv := &SID_Voice{
+		sid:  sid,
+		v:    uint8(v),
+		wave: sid_pulse,
+	}
+	sv.SetPulseWidth(0.5)
+	sv.SetEnvelope(0, 9, 10, 9)
+	// full volume and all filter modes off
+	if sid.mode&15 == 0 {
+		sid.Write(24, 15)
+	}
+	return sv, nil
+}
+
+// Set the waveform (and ring/sync) control bits.
+func (sv *SID_Voice) SetWaveform(wave uint8) {
+	sv.wave = wave &^ (sid_gate | sid_test)
+	vc := &sv.sid.voice[sv.v]
+	sv.sid.Write(sv.v*7+4, sv.wave|(vc.ctrl&sid_gate))
+}
+
+// Set the envelope rates (0..15) and sustain level (0..15).
+func (sv *SID_Voice) SetEnvelope(a, d, s, r uint8) {
+	sv.sid.Write(sv.v*7+5, ((a&15)<<4)|(d&15))
+	sv.sid.Write(sv.v*7+6, ((s&15)<<4)|(r&15))
+}
+
+// Set the pulse width (0..1).
+func (sv *SID_Voice) SetPulseWidth(w float32) {
+	pw := uint16(w * 4095.0)
+	if w <= 0 {
+		pw = 0
+	} else if w >= 1 {
+		pw = 4095
+	}
+	sv.sid.Write(sv.v*7+2, uint8(pw))
+	sv.sid.Write(sv.v*7+3, uint8(pw>>8))
+}
+
+func (sv *SID_Voice) NoteOn(note uint, velocity uint) {
+	fn := float64(midi_to_frequency(note)) * (1 << 24) / sid_clock
+	if fn > 0xffff {
+		return
+	}
+	f := uint16(fn + 0.5)
+	sv.note = note
+	base := sv.v * 7
+	sv.sid.Write(base, uint8(f))
+	sv.sid.Write(base+1, uint8(f>>8))
+	// retrigger the gate
+	sv.sid.Write(base+4, sv.wave)
+	sv.sid.Write(base+4, sv.wave|sid_gate)
+}
+
+func (sv *SID_Voice) NoteOff() {
+	sv.sid.Write(sv.v*7+4, sv.wave)
+}
+
+// The modulation wheel sets the pulse width, brightness and resonance set
+// the filter cutoff and resonance for all voices.
+func (sv *SID_Voice) ControlChange(cc uint, val uint) {
+	switch cc {
+	case cc_modulation:
+		sv.SetPulseWidth(0.5 - (0.45 * cc_to_float(val)))
+	case cc_resonance:
+		sv.sid.Write(23, (uint8(val>>3)<<4)|sv.sid.route)
+	case cc_brightness:
+		c := uint16(cc_to_float(val) * 2047.0)
+		sv.sid.Write(21, uint8(c&7))
+		sv.sid.Write(22, uint8(c>>3))
+	}
+}
+
+//-----------------------------------------------------------------------------
@@ -0,0 +1,147 @@
+//-----------------------------------------------------------------------------
+/*
+
+Unison Oscillator
+
+Run N detuned copies of an oscillator with random initial phases and mix
+them down to stereo. With band limited sawtooths this is a supersaw.
+
+*/
+//-----------------------------------------------------------------------------
+
+package main
+
+import (
+	"errors"
+	"math"
+)
+
+//-----------------------------------------------------------------------------
+
+type Unison struct {
+	voices []Oscillator // the unison voices
+	ratio  []float32    // per voice frequency ratio
+	pan    []float32    // per voice stereo position (-1..1)
+	gl, gr []float32    // per voice left/right gain
+	detune float32      // total detune spread in cents
+	spread float32      // stereo spread (0..1)
+	f      float32      // base frequency
+	rate   int          // sample rate
+}
+
+// Return a unison oscillator.
+func NewUnison(
+	osc func(f float32, rate int) Oscillator, // oscillator constructor
+	n int, // number of voices
+	detune float32, // total detune spread in cents
+	spread float32, // stereo spread (0..1)
+	f float32, // frequency
+	rate int, // sample rate
+	seed uint32, // seed for the random initial phases
+) (*Unison, error) {
+
+	if n <= 0 {
+		return nil, errors.New("bad number of voices")
+	}
+	if spread < 0 || spread > 1.0 {
+		return nil, errors.New("bad stereo spread")
+	}
+
+	u := &Unison{
+		voices: make([]Oscillator, n),
+		ratio:  make([]float32, n),
+		pan:    make([]float32, n),
+		gl:     make([]float32, n),
+		gr:     make([]float32, n),
+	}
+
+	rng := NewPRNG(seed)
+	// the stereo positions are evenly spaced from left to right and are given
+	// to the voices from the centre out on alternating sides, so the position
+	// doesn't follow the detune
+	lo, hi := (n-1)/2, n/2
+	if lo == hi {
+		hi++
+	}
+	for i := range u.voices {
+		u.voices[i] = osc(f, rate)
+		u.voices[i].SetPhase(rng.Float())
+		j := lo
+		if i&1 == 0 {
+			lo--
+		} else {
+			j = hi
+			hi++
+		}
+		if n > 1 {
+			u.pan[i] = (2.0*float32(j)/float32(n-1) - 1.0)
+		}
+	}
+
+	u.SetSpread(spread)
+	u.detune = detune
+	u.SetStep(f, rate)
+	return u, nil
+}
+
+// Return a supersaw: a unison of band limited sawtooths.
+func NewUnison_Supersaw(n int, detune, spread, f float32, rate int, seed uint32) (*Unison, error) {
+	osc := func(f float32, rate int) Oscillator {
+		return NewBLEP_Sawtooth(f, rate)
+	}
+	return NewUnison(osc, n, detune, spread, f, rate, seed)
+}
+
+//-----------------------------------------------------------------------------
+
+func (u *Unison) SetStep(f float32, rate int) {
+	u.f = f
+	u.rate = rate
+	n := len(u.voices)
+	for i, v := range u.voices {
+		// spread the voices evenly across the detune range
+		var c float32
+		if n > 1 {
+			c = u.detune * (float32(i)/float32(n-1) - 0.5)
+		}
+		u.ratio[i] = float32(math.Pow(2.0, float64(c)/1200.0))
+		v.SetStep(f*u.ratio[i], rate)
+	}
+}
+
+// Set the total detune spread in cents.
+func (u *Unison) SetDetune(detune float32) {
+	u.detune = detune
+	u.SetStep(u.f, u.rate)
+}
+
+// Set the stereo spread (0..1).
+func (u *Unison) SetSpread(spread float32) {
+	u.spread = spread
+	// equal power panning, normalised for the number of voices
+	k := float32(1.0 / math.Sqrt(float64(len(u.voices))))
+	for i := range u.voices {
+		a := float64(u.pan[i]*spread+1.0) * math.Pi / 4.0
+		u.gl[i] = k * float32(math.Cos(a))
+		u.gr[i] = k * float32(math.Sin(a))
+	}
+}
+
+// Return a stereo sample.
+func (u *Unison) SampleStereo() (float32, float32) {
+	var l, r float32
+	for i, v := range u.voices {
+		y := v.Sample()
+		l += y * u.gl[i]
+		r += y * u.gr[i]
+	}
+	return l, r
+}
+
+// Return a mono sample.
+func (u *Unison) Sample() float32 {
+	l, r := u.SampleStereo()
+	return (l + r) * math.Sqrt2 / 2.0
+}
+
+//-----------------------------------------------------------------------------
@@ -0,0 +1,275 @@
+//-----------------------------------------------------------------------------
+/*
+
+WAV File Loading
+
+Read RIFF/WAVE files (8/16/24/32 bit integer PCM or 32/64 bit float) and
+convert them to float32 samples. The root note and loop points are read
+from a "smpl" chunk if there is one. Single cycle and multi-frame wavetables
+can be loaded from WAV files into LUT or Morph oscillators.
+
+*/
+//-----------------------------------------------------------------------------
+
+package main
+
+import (
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"io/ioutil"
+	"math"
+	"strconv"
+	"strings"
+)
+
+//-----------------------------------------------------------------------------
+
+const wav_format_pcm = 1
+const wav_format_float = 3
+const wav_format_extensible = 0xfffe
+
+type WAV struct {
+	rate       int         // sample rate
+	data       [][]float32 // per channel samples
+	frame_size int         // wavetable frame size from a "clm " chunk, else 0
+	root       int         // midi unity note from a "smpl" chunk, else -1
+	loop       bool        // a loop was found in a "smpl" chunk
+	loop_start int         // first sample of the loop
+	loop_end   int         // last sample of the loop (inclusive)
+}
+
+// Return the number of channels.
+func (w *WAV) Channels() int {
+	return len(w.data)
+}
+
+// Return the number of samples per channel.
+func (w *WAV) Length() int {
+	if len(w.data) == 0 {
+		return 0
+	}
+	return len(w.data[0])
+}
+
+//-----------------------------------------------------------------------------
+
+// Read a WAV file.
+func read_wav(path string) (*WAV, error) {
+	buf, err := ioutil.ReadFile(path)
+	if err != nil {
+		return nil, err
+	}
+	w, err := decode_wav(buf)
+	if err != nil {
+		return nil, fmt.Errorf("%s: %s", path, err)
+	}
+	return w, nil
+}
+
+// Decode the contents of a WAV file.
+func decode_wav(buf []byte) (*WAV, error) {
+	le := binary.LittleEndian
+
+	if len(buf) < 12 || string(buf[0:4]) != "RIFF" || string(buf[8:12]) != "WAVE" {
+		return nil, errors.New("not a RIFF/WAVE file")
+	}
+
+	w := &WAV{root: -1}
+	var format, channels, bits int
+	var data []byte
+	have_fmt := false
+
+	// iterate over the chunks
+	buf = buf[12:]
+	for len(buf) >= 8 {
+		id := string(buf[0:4])
+		n := int(le.Uint32(buf[4:8]))
+		buf = buf[8:]
+		if n > len(buf) {
+			// truncated chunk - take what we have
+			n = len(buf)
+		}
+		chunk := buf[:n]
+		switch id {
+		case "fmt ":
+			if n < 16 {
+				return nil, errors.New("bad fmt chunk")
+			}
+			format = int(le.Uint16(chunk[0:2]))
+			channels = int(le.Uint16(chunk[2:4]))
+			w.rate = int(le.Uint32(chunk[4:8]))
+			bits = int(le.Uint16(chunk[14:16]))
+			if format == wav_format_extensible && n >= 26 {
+				// the sub-format guid starts with the format code
+				format = int(le.Uint16(chunk[24:26]))
+			}
+			have_fmt = true
+		case "data":
+			data = chunk
+		case "smpl":
+			if n >= 36 {
+				w.root = int(le.Uint32(chunk[12:16]))
+				if le.Uint32(chunk[28:32]) > 0 && n >= 60 {
+					// use the first loop
+					w.loop = true
+					w.loop_start = int(le.Uint32(chunk[44:48]))
+					w.loop_end = int(le.Uint32(chunk[48:52]))
+				}
+			}
+		case "clm ":
+			// Serum wavetables: "<!>2048 ..."
+			s := string(chunk)
+			if strings.HasPrefix(s, "<!>") && len(s) >= 7 {
+				if size, err := strconv.Atoi(strings.TrimSpace(s[3:7])); err == nil {
+					w.frame_size = size
+				}
+			}
+		}
+		// chunks are padded to an even size
+		n += n & 1
+		if n > len(buf) {
+			n = len(buf)
+		}
+		buf = buf[n:]
+	}
+
+	if !have_fmt {
+		return nil, errors.New("no fmt chunk")
+	}
+	if data == nil {
+		return nil, errors.New("no data chunk")
+	}
+	if channels <= 0 {
+		return nil, errors.New("bad number of channels")
+	}
+
+	// work out the sample decoder
+	var decode func(b []byte) float32
+	switch {
+	case format == wav_format_pcm && bits == 8:
+		decode = func(b []byte) float32 {
+			return float32(int(b[0])-128) / 128.0
+		}
+	case format == wav_format_pcm && bits == 16:
+		decode = func(b []byte) float32 {
+			return float32(int16(le.Uint16(b))) / 32768.0
+		}
+	case format == wav_format_pcm && bits == 24:
+		decode = func(b []byte) float32 {
+			x := int32(uint32(b[0])<<8|uint32(b[1])<<16|uint32(b[2])<<24) >> 8
+			return float32(x) / 8388608.0
+		}
+	case format == wav_format_pcm && bits == 32:
+		decode = func(b []byte) float32 {
+			return float32(float64(int32(le.Uint32(b))) / 2147483648.0)
+		}
+	case format == wav_format_float && bits == 32:
+		decode = func(b []byte) float32 {
+			return math.Float32frombits(le.Uint32(b))
+		}
+	case format == wav_format_float && bits == 64:
+		decode = func(b []byte) float32 {
+			return float32(math.Float64frombits(le.Uint64(b)))
+		}
+	default:
+		return nil, fmt.Errorf("unsupported format %d with %d bits per sample", format, bits)
+	}
+
+	// de-interleave the samples
+	k := bits / 8
+	n := len(data) / (k * channels)
+	w.data = make([][]float32, channels)
+	for i := range w.data {
+		w.data[i] = make([]float32, n)
+	}
+	for i := 0; i < n; i++ {
+		for j := 0; j < channels; j++ {
+			ofs := ((i * channels) + j) * k
+			w.data[j][i] = decode(data[ofs : ofs+k])
+		}
+	}
+
+	return w, nil
+}
+
+//-----------------------------------------------------------------------------
+
+// Return true if n is a power of 2.
+func is_power_of_2(n int) bool {
+	return n > 0 && n&(n-1) == 0
+}
+
+// Return the wavetable frames in a WAV file.
+// If size is 0 the frame size will be detected.
+func wav_frames(w *WAV, size int) ([][]float32, error) {
+	n := w.Length()
+	if n == 0 {
+		return nil, errors.New("no samples")
+	}
+	if size == 0 {
+		size = w.frame_size
+	}
+	if size == 0 {
+		if is_power_of_2(n) && n <= 4096 {
+			// a single cycle
+			size = n
+		} else {
+			// the common multi-frame sizes
+			for _, k := range []int{2048, 1024, 4096, 512, 256} {
+				if n%k == 0 {
+					size = k
+					break
+				}
+			}
+		}
+	}
+	if size <= 0 || n%size != 0 {
+		return nil, fmt.Errorf("can't split %d samples into wavetable frames", n)
+	}
+	// use the first channel
+	frames := make([][]float32, n/size)
+	for i := range frames {
+		frames[i] = w.data[0][i*size : (i+1)*size]
+	}
+	return frames, nil
+}
+
+// Load the wavetable frames from a WAV file.
+// If size is 0 the frame size will be detected.
+func load_wavetable(path string, size int) ([][]float32, error) {
+	w, err := read_wav(path)
+	if err != nil {
+		return nil, err
+	}
+	frames, err := wav_frames(w, size)
+	if err != nil {
+		return nil, fmt.Errorf("%s: %s", path, err)
+	}
+	return frames, nil
+}
+
+//-----------------------------------------------------------------------------
+
+// Return a LUT oscillator using the first wavetable frame of a WAV file.
+func NewLUT_Wav(path string, size int, f float32, rate int) (*LUT, error) {
+	frames, err := load_wavetable(path, size)
+	if err != nil {
+		return nil, err
+	}
+	t := &LUT{}
+	t.SetTable(frames[0])
+	t.SetStep(f, rate)
+	return t, nil
+}
+
+// Return a morphing oscillator using all wavetable frames of a WAV file.
+func NewMorph_Wav(path string, size int, f float32, rate int) (*Morph, error) {
+	frames, err := load_wavetable(path, size)
+	if err != nil {
+		return nil, err
+	}
+	return NewMorph(frames, f, rate)
+}
+
+//-----------------------------------------------------------------------------
@@ -0,0 +1,347 @@
+//-----------------------------------------------------------------------------
+/*
+
+Digital Waveguide Instruments
+
+Clarinet: a bore delay line terminated by a reed table nonlinearity.
+Flute: a bore delay line excited by a jet delay line with a cubic nonlinearity.
+Bowed String: two delay lines (neck and bridge) coupled by a bow friction table.
+
+These follow the models in the Synthesis ToolKit (STK) by Perry Cook and
+Gary Scavone.
+
+The breath pressure or bow pressure can be driven from MIDI CCs:
+
+CC1 (modulation) - vibrato depth
+CC2 (breath) - breath pressure (wind) or bow pressure (string)
+CC11 (expression) - bow velocity (string)
+
+*/
+//-----------------------------------------------------------------------------
+
+package main
+
+import "errors"
+
+//-----------------------------------------------------------------------------
+
+const wg_min_frequency = 20.0 // lowest note frequency
+const vibrato_frequency = 5.5 // vibrato frequency in Hz
+
+//-----------------------------------------------------------------------------
+// filters
+
+type onepole struct {
+	b0, a1 float32
+	gain   float32
+	y      float32
+}
+
+func (f *onepole) set_pole(p, gain float32) {
+	if p > 0 {
+		f.b0 = 1.0 - p
+	} else {
+		f.b0 = 1.0 + p
+	}
+	f.a1 = -p
+	f.gain = gain
+}
+
+func (f *onepole) tick(x float32) float32 {
+	f.y = (f.gain * f.b0 * x) - (f.a1 * f.y)
+	return f.y
+}
+
+type dcblock struct {
+	x1, y1 float32
+}
+
+func (f *dcblock) tick(x float32) float32 {
+	y := x - f.x1 + (0.995 * f.y1)
+	f.x1 = x
+	f.y1 = y
+	return y
+}
+
+//-----------------------------------------------------------------------------
+// Clarinet
+
+type Clarinet struct {
+	bore     *Delay  // bore delay line
+	env      *ADSR   // breath envelope
+	vibrato  *LUT    // vibrato oscillator
+	noise    *Noise  // breath noise
+	x1       float32 // reflection filter state
+	pressure float32 // maximum breath pressure
+	vib      float32 // vibrato depth
+	rate     int     // sample rate
+}
+
+// Return a clarinet voice.
+func NewClarinet(rate int) (*Clarinet, error) {
+	env, err := NewADSR_Envelope(0.02, 0.01, 1.0, 0.05, rate)
+	if err != nil {
+		return nil, err
+	}
+	return &Clarinet{
+		bore:    NewDelay(int(float32(rate)/wg_min_frequency) + 1),
+		env:     env,
+		vibrato: NewLUT_Sine(vibrato_frequency, rate),
+		noise:   NewNoise_White(1),
+		rate:    rate,
+	}, nil
+}
+
+// Return the reed table reflection coefficient.
+func reed_table(x float32) float32 {
+	y := 0.7 + (-0.3 * x)
+	if y > 1.0 {
+		return 1.0
+	}
+	if y < -1.0 {
+		return -1.0
+	}
+	return y
+}
+
+// Start blowing a note.
+func (c *Clarinet) NoteOn(note uint, velocity uint) {
+	f := midi_to_frequency(note)
+	if f < wg_min_frequency {
+		f = wg_min_frequency
+	}
+	c.bore.SetDelay((float32(c.rate) / f * 0.5) - 1.5)
+	c.pressure = 0.55 + (0.3 * cc_to_float(velocity))
+	c.env.Attack()
+}
+
+// Stop blowing.
+func (c *Clarinet) NoteOff() {
+	c.env.Release()
+}
+
+// Handle a MIDI control change.
+func (c *Clarinet) ControlChange(cc uint, val uint) {
+	switch cc {
+	case cc_modulation:
+		c.vib = 0.1 * cc_to_float(val)
+	case cc_breath:
+		c.pressure = 0.85 * cc_to_float(val)
+	}
+}
+
+func (c *Clarinet) Sample() float32 {
+	breath := c.pressure * c.env.Sample()
+	breath += breath * ((0.2 * c.noise.Sample()) + (c.vib * c.vibrato.Sample()))
+	// two point average reflection filter
+	y := c.bore.LastOut()
+	p := -0.95 * 0.5 * (y + c.x1)
+	c.x1 = y
+	p -= breath
+	return c.bore.Tick(breath + (p * reed_table(p)))
+}
+
+//-----------------------------------------------------------------------------
+// Flute
+
+const flute_jet_ratio = 0.32
+const flute_jet_reflection = 0.5
+const flute_end_reflection = 0.5
+
+type Flute struct {
+	jet      *Delay  // jet delay line
+	bore     *Delay  // bore delay line
+	env      *ADSR   // breath envelope
+	vibrato  *LUT    // vibrato oscillator
+	noise    *Noise  // breath noise
+	filter   onepole // bore loss filter
+	dc       dcblock // dc blocker
+	pressure float32 // maximum breath pressure
+	vib      float32 // vibrato depth
+	rate     int     // sample rate
+}
+
+// Return a flute voice.
+func NewFlute(rate int) (*Flute, error) {
+	env, err := NewADSR_Envelope(0.05, 0.01, 1.0, 0.1, rate)
+	if err != nil {
+		return nil, err
+	}
+	n := int(float32(rate)/wg_min_frequency) + 1
+	f := &Flute{
+		jet:     NewDelay(n),
+		bore:    NewDelay(n),
+		env:     env,
+		vibrato: NewLUT_Sine(vibrato_frequency, rate),
+		noise:   NewNoise_White(2),
+		rate:    rate,
+	}
+	f.filter.set_pole(0.7-(0.1*22050.0/float32(rate)), 1.0)
+	return f, nil
+}
+
+// Return the jet table value (a cubic nonlinearity).
+func jet_table(x float32) float32 {
+	y := x * ((x * x) - 1.0)
+	if y > 1.0 {
+		return 1.0
+	}
+	if y < -1.0 {
+		return -1.0
+	}
+	return y
+}
+
+// Start blowing a note.
+func (f *Flute) NoteOn(note uint, velocity uint) {
+	freq := midi_to_frequency(note)
+	if freq < wg_min_frequency {
+		freq = wg_min_frequency
+	}
+	// the flute overblows, so the bore is longer than the note period
+	d := (float32(f.rate) / (freq * 0.66666)) - 2.0
+	f.bore.SetDelay(d)
+	f.jet.SetDelay(d * flute_jet_ratio)
+	f.pressure = 1.1 + (0.2 * cc_to_float(velocity))
+	f.env.Attack()
+}
+
+// Stop blowing.
+func (f *Flute) NoteOff() {
+	f.env.Release()
+}
+
+// Handle a MIDI control change.
+func (f *Flute) ControlChange(cc uint, val uint) {
+	switch cc {
+	case cc_modulation:
+		f.vib = 0.1 * cc_to_float(val)
+	case cc_breath:
+		f.pressure = 1.3 * cc_to_float(val)
+	}
+}
+
+func (f *Flute) Sample() float32 {
+	breath := f.pressure * f.env.Sample()
+	breath += breath * ((0.15 * f.noise.Sample()) + (f.vib * f.vibrato.Sample()))
+	// inverting reflection at the open end of the bore
+	t := f.dc.tick(-f.filter.tick(f.bore.LastOut()))
+	p := breath - (flute_jet_reflection * t)
+	p = f.jet.Tick(p)
+	p = jet_table(p) + (flute_end_reflection * t)
+	return 0.3 * f.bore.Tick(p)
+}
+
+//-----------------------------------------------------------------------------
+// Bowed String
+
+const bowed_beta_ratio = 0.127236 // bow position on the string
+
+type Bowed struct {
+	neck     *Delay  // nut to bow delay line
+	bridge   *Delay  // bow to bridge delay line
+	env      *ADSR   // bow velocity envelope
+	vibrato  *LUT    // vibrato oscillator
+	filter   onepole // string loss filter
+	velocity float32 // maximum bow velocity
+	slope    float32 // bow table slope (from the bow pressure)
+	vib      float32 // vibrato depth
+	d        float32 // base delay length
+	rate     int     // sample rate
+}
+
+// Return a bowed string voice.
+func NewBowed(pressure float32, rate int) (*Bowed, error) {
+	if pressure < 0 || pressure > 1.0 {
+		return nil, errors.New("bad bow pressure")
+	}
+	env, err := NewADSR_Envelope(0.02, 0.01, 1.0, 0.1, rate)
+	if err != nil {
+		return nil, err
+	}
+	n := int(float32(rate)/wg_min_frequency) + 1
+	b := &Bowed{
+		neck:    NewDelay(n),
+		bridge:  NewDelay(n),
+		env:     env,
+		vibrato: NewLUT_Sine(vibrato_frequency, rate),
+		rate:    rate,
+	}
+	b.filter.set_pole(0.75-(0.2*22050.0/float32(rate)), 0.95)
+	b.SetPressure(pressure)
+	return b, nil
+}
+
+// Set the bow pressure (0..1).
+func (b *Bowed) SetPressure(pressure float32) {
+	b.slope = 5.0 - (4.0 * pressure)
+}
+
+// Return the bow table friction value.
+func (b *Bowed) bow_table(x float32) float32 {
+	y := x * b.slope
+	if y < 0 {
+		y = -y
+	}
+	y += 0.75
+	y *= y
+	y = 1.0 / (y * y)
+	if y > 1.0 {
+		return 1.0
+	}
+	return y
+}
+
+// Start bowing a note.
+func (b *Bowed) NoteOn(note uint, velocity uint) {
+	f := midi_to_frequency(note)
+	if f < wg_min_frequency {
+		f = wg_min_frequency
+	}
+	b.d = (float32(b.rate) / f) - 4.0
+	b.bridge.SetDelay(b.d * bowed_beta_ratio)
+	b.neck.SetDelay(b.d * (1.0 - bowed_beta_ratio))
+	b.velocity = 0.03 + (0.2 * cc_to_float(velocity))
+	b.env.Attack()
+}
+
+// Stop bowing.
+func (b *Bowed) NoteOff() {
+	b.env.Release()
+}
+
+// Handle a MIDI control change.
+func (b *Bowed) ControlChange(cc uint, val uint) {
+	switch cc {
+	case cc_modulation:
+		b.vib = 0.01 * cc_to_float(val)
+		if b.vib == 0 {
+			// back to the unmodulated neck length
+			b.neck.SetDelay(b.d * (1.0 - bowed_beta_ratio))
+		}
+	case cc_breath:
+		b.SetPressure(cc_to_float(val))
+	case cc_expression:
+		b.velocity = 0.03 + (0.2 * cc_to_float(val))
+	}
+}
+
+func (b *Bowed) Sample() float32 {
+	if b.vib != 0 {
+		// vibrato by modulating the neck length
+		b.neck.SetDelay(b.d * (1.0 - bowed_beta_ratio) * (1.0 + (b.vib * b.vibrato.Sample())))
+	}
+	v := b.velocity * b.env.Sample()
+	bridge := -b.filter.tick(b.bridge.LastOut())
+	nut := -b.neck.LastOut()
+	dv := v - (bridge + nut)
+	var nv float32
+	if v != 0 {
+		nv = dv * b.bow_table(dv)
+	}
+	b.neck.Tick(bridge + nv)
+	b.bridge.Tick(nut + nv)
+	return b.bridge.LastOut()
+}
+
+//-----------------------------------------------------------------------------
@@ -0,0 +1,230 @@
+//-----------------------------------------------------------------------------
+/*
+
+Mip-Mapped Wavetable Oscillator
+
+A single lookup table is either too dull at low notes or aliases at high
+notes. Here we build one band limited table per octave from a harmonic
+spectrum. Each table holds only the harmonics that stay below the Nyquist
+frequency for the top note of its octave (and that the table size can
+represent). The oscillator crossfades between adjacent tables as the
+frequency changes.
+
+*/
+//-----------------------------------------------------------------------------
+
+package main
+
+import (
+	"math"
+	"sync"
+)
+
+//-----------------------------------------------------------------------------
+
+const wt_table_size = 2048     // samples per wavetable
+const wt_base_frequency = 20.0 // top frequency of the lowest octave table
+const wt_octaves = 11          // number of octave tables
+
+//-----------------------------------------------------------------------------
+// harmonic spectra: amplitude of the n-th harmonic is at index n-1
+
+// Return the harmonic spectrum of a sawtooth wave.
+func sawtooth_spectrum(n int) []float32 {
+	s := make([]float32, n)
+	for i := range s {
+		s[i] = float32(1.0 / float64(i+1))
+	}
+	return s
+}
+
+// Return the harmonic spectrum of a square wave.
+func square_spectrum(n int) []float32 {
+	s := make([]float32, n)
+	for i := 0; i < n; i += 2 {
+		s[i] = float32(1.0 / float64(i+1))
+	}
+	return s
+}
+
+// Return the harmonic spectrum of a triangle wave.
+func triangle_spectrum(n int) []float32 {
+	s := make([]float32, n)
+	sign := 1.0
+	for i := 0; i < n; i += 2 {
+		s[i] = float32(sign / float64((i+1)*(i+1)))
+		sign = -sign
+	}
+	return s
+}
+
+//-----------------------------------------------------------------------------
+
+// Return a table of n samples summing the first h harmonics of a spectrum.
+func harmonic_table(spectrum []float32, h, n int) []float32 {
+	table := make([]float32, n)
+	if h > len(spectrum) {
+		h = len(spectrum)
+	}
+	for i := 0; i < h; i++ {
+		if spectrum[i] == 0 {
+			continue
+		}
+		k := float64(i+1) * 2.0 * math.Pi / float64(n)
+		for j := range table {
+			table[j] += spectrum[i] * float32(math.Sin(k*float64(j)))
+		}
+	}
+	return table
+}
+
+// Scale a set of tables so the reference table has a peak value of 1.
+func normalise_tables(tables [][]float32, ref []float32) {
+	var peak float32
+	for _, v := range ref {
+		if v > peak {
+			peak = v
+		} else if -v > peak {
+			peak = -v
+		}
+	}
+	if peak == 0 {
+		return
+	}
+	for _, table := range tables {
+		for j := range table {
+			table[j] /= peak
+		}
+	}
+}
+
+//-----------------------------------------------------------------------------
+
+type Wavetable struct {
+	tables [][]float32 // per octave tables, most harmonics first
+	xrange float32     // table size
+	x      float32     // table position
+	step   float32     // table step per sample
+	t0, t1 []float32   // tables to crossfade
+	k      float32     // crossfade amount from t0 to t1
+}
+
+// Return the number of harmonics below nyquist for a top frequency.
+// This is limited to the harmonics a table can hold without folding over.
+func wt_harmonics(fmax float64, rate int) int {
+	h := int(float64(rate) / (2.0 * fmax))
+	if h > wt_table_size/2-1 {
+		h = wt_table_size/2 - 1
+	}
+	return h
+}
+
+// Return the per octave tables for a harmonic spectrum.
+func wavetable_tables(spectrum []float32, rate int) [][]float32 {
+	tables := make([][]float32, wt_octaves)
+	fmax := wt_base_frequency
+	for i := range tables {
+		tables[i] = harmonic_table(spectrum, wt_harmonics(fmax, rate), wt_table_size)
+		fmax *= 2.0
+	}
+	// normalise all tables with the peak of the fullest table
+	normalise_tables(tables, tables[0])
+	return tables
+}
+
+// Return a wavetable oscillator using a set of per octave tables.
+func new_wavetable(tables [][]float32, f float32, rate int) *Wavetable {
+	t := &Wavetable{
+		tables: tables,
+		xrange: wt_table_size,
+	}
+	t.SetStep(f, rate)
+	return t
+}
+
+// Return a mip-mapped wavetable oscillator for a harmonic spectrum.
+func NewWavetable(spectrum []float32, f float32, rate int) *Wavetable {
+	return new_wavetable(wavetable_tables(spectrum, rate), f, rate)
+}
+
+//-----------------------------------------------------------------------------
+// The tables for the standard waveforms are built once per sample rate and
+// shared between oscillators.
+
+type wt_key struct {
+	name string // waveform name
+	rate int    // sample rate
+}
+
+var wt_cache = map[wt_key][][]float32{}
+var wt_cache_lock sync.Mutex
+
+// Return the shared tables for a standard waveform spectrum.
+func wt_shared(name string, spectrum func(n int) []float32, rate int) [][]float32 {
+	key := wt_key{name, rate}
+	wt_cache_lock.Lock()
+	defer wt_cache_lock.Unlock()
+	tables, ok := wt_cache[key]
+	if !ok {
+		tables = wavetable_tables(spectrum(wt_harmonics(wt_base_frequency, rate)), rate)
+		wt_cache[key] = tables
+	}
+	return tables
+}
+
+func NewWavetable_Sawtooth(f float32, rate int) *Wavetable {
+	return new_wavetable(wt_shared("sawtooth", sawtooth_spectrum, rate), f, rate)
+}
+
+func NewWavetable_Square(f float32, rate int) *Wavetable {
+	return new_wavetable(wt_shared("square", square_spectrum, rate), f, rate)
+}
+
+func NewWavetable_Triangle(f float32, rate int) *Wavetable {
+	return new_wavetable(wt_shared("triangle", triangle_spectrum, rate), f, rate)
+}
+
+//-----------------------------------------------------------------------------
+
+// Set the oscillator frequency and select the octave tables to use.
+func (t *Wavetable) SetStep(f float32, rate int) {
+	t.step = f * t.xrange / float32(rate)
+	// fractional octave above the lowest table
+	o := float32(0)
+	if f > wt_base_frequency/2.0 {
+		o = float32(math.Log2(float64(f) / (wt_base_frequency / 2.0)))
+	}
+	i := int(o)
+	n := len(t.tables) - 1
+	if i >= n {
+		t.t0 = t.tables[n]
+		t.t1 = t.tables[n]
+		t.k = 0
+		return
+	}
+	t.t0 = t.tables[i]
+	t.t1 = t.tables[i+1]
+	t.k = o - float32(i)
+}
+
+func (t *Wavetable) Sample() float32 {
+	// linear interpolation
+	x0 := int(t.x)
+	x1 := x0 + 1
+	if x1 == len(t.t0) {
+		x1 = 0
+	}
+	dx := t.x - float32(x0)
+	y0 := t.t0[x0] + (dx * (t.t0[x1] - t.t0[x0]))
+	y1 := t.t1[x0] + (dx * (t.t1[x1] - t.t1[x0]))
+	// crossfade between octave tables
+	y := y0 + (t.k * (y1 - y0))
+	// step the x position
+	t.x += t.step
+	if t.x >= t.xrange {
+		t.x -= t.xrange
+	}
+	return y
+}
+
+//-----------------------------------------------------------------------------